@@ -0,0 +1,130 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"strings"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Role that has every other role.
+const adminRole = "admin"
+
+// accountOf returns services account author of msg is logged in to, or an
+// empty string, if they're not, or we don't know. Account is taken from the
+// account message tag, channel state (kept up to date with extended-join and
+// account-notify), or an earlier :identify by the same nick!user@host.
+func accountOf(msg irc.Message) string {
+	if account := msg.Tags["account"]; account != "" {
+		return account
+	}
+	if msg.Prefix == nil {
+		return ""
+	}
+
+	network := msg.Context["Network"]
+	if conn := irc.GetConnection(network); conn != nil {
+		if u, ok := conn.User(msg.Prefix.Name); ok && u.Account != "" {
+			return u.Account
+		}
+	}
+	return identifiedAccount(network, msg.Prefix)
+}
+
+// aclContext returns configuration context "ACL" is looked up in: the network,
+// and the channel, if msg was sent to one. Source is left out on purpose, as
+// anyone can use any free nick.
+func aclContext(msg irc.Message) map[string]string {
+	context := map[string]string{"Network": msg.Context["Network"]}
+	if len(msg.Params) > 0 && isupport(context["Network"]).IsChannel(msg.Params[0]) {
+		context["Target"] = msg.Params[0]
+	}
+	return context
+}
+
+// roleEntries returns entries configured for role in "ACL", which maps role
+// names to lists of entries.
+func roleEntries(context map[string]string, role string) []string {
+	acl, _ := cfg.Lookup(context, "ACL").(map[string]interface{})
+	list, _ := acl[role].([]interface{})
+
+	var r []string
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+// aclMatch checks if author of msg matches an ACL entry: "$a:account" matches
+// users logged in to account, "$a" matches everyone logged in, and anything
+// else is a nick!user@host mask, with * and ? wildcards.
+func aclMatch(msg irc.Message, entry string) bool {
+	server := isupport(msg.Context["Network"])
+
+	switch {
+	case entry == "$a":
+		return accountOf(msg) != ""
+	case strings.HasPrefix(entry, "$a:"):
+		account := accountOf(msg)
+		return account != "" && server.EqualFold(account, entry[len("$a:"):])
+	case msg.Prefix == nil:
+		return false
+	default:
+		return maskMatch(server.Fold(entry), server.Fold(msg.Prefix.String()))
+	}
+}
+
+// hasRole checks if author of msg has role, on the network and channel msg
+// came from.
+func hasRole(msg irc.Message, role string) bool {
+	context := aclContext(msg)
+
+	roles := []string{role}
+	if role != adminRole {
+		roles = append(roles, adminRole)
+	}
+
+	for _, r := range roles {
+		for _, entry := range roleEntries(context, r) {
+			if aclMatch(msg, entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maskMatch matches s against mask, in which * matches any number of
+// characters, and ? matches exactly one.
+func maskMatch(mask, s string) bool {
+	// Position to go back to after a mismatch: just after the last *, and
+	// the place in s it's matching up to.
+	star, next := -1, 0
+
+	m, i := 0, 0
+	for i < len(s) {
+		switch {
+		case m < len(mask) && (mask[m] == '?' || mask[m] == s[i]):
+			m++
+			i++
+		case m < len(mask) && mask[m] == '*':
+			star, next = m, i
+			m++
+		case star >= 0:
+			next++
+			m, i = star+1, next
+		default:
+			return false
+		}
+	}
+
+	for m < len(mask) && mask[m] == '*' {
+		m++
+	}
+	return m == len(mask)
+}
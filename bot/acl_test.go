@@ -0,0 +1,144 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+var maskTests = []struct {
+	mask  string
+	s     string
+	match bool
+}{
+	{"*!*@*", "nick!user@host", true},
+	{"*!*@admin.example.com", "nick!user@admin.example.com", true},
+	{"*!*@admin.example.com", "nick!user@evil.admin.example.com", false},
+	{"*!*@*.example.com", "nick!user@evil.admin.example.com", true},
+	{"nick!?ser@host", "nick!user@host", true},
+	{"nick!?ser@host", "nick!ser@host", false},
+	{"n*k!*", "nick!user@host", true},
+	{"n*k!*", "nickname!user@host", false},
+	{"*a*b", "aaab", true},
+	{"*a*b", "aaba", false},
+	{"", "", true},
+	{"*", "", true},
+	{"?", "", false},
+}
+
+func TestMaskMatch(t *testing.T) {
+	for _, e := range maskTests {
+		if r := maskMatch(e.mask, e.s); r != e.match {
+			t.Errorf("%q on %q: expected %v, got %v", e.mask, e.s, e.match, r)
+		}
+	}
+}
+
+var roleTests = []struct {
+	desc    string
+	prefix  irc.Prefix
+	account string // account tag
+	role    string
+	has     bool
+}{
+	{"admin by account", irc.Prefix{Name: "someone", User: "u", Host: "h"}, "Admin-Account", "admin", true},
+	{"admin by hostmask", irc.Prefix{Name: "someone", User: "u", Host: "ADMIN.example.com"}, "", "admin", true},
+	{"admin has other roles", irc.Prefix{Name: "someone", User: "u", Host: "admin.example.com"}, "", "op", true},
+	{"logged in user", irc.Prefix{Name: "someone", User: "u", Host: "h"}, "other-account", "op", true},
+	{"logged in user isn't admin", irc.Prefix{Name: "someone", User: "u", Host: "h"}, "other-account", "admin", false},
+	{"anonymous user", irc.Prefix{Name: "someone", User: "u", Host: "h"}, "", "op", false},
+	{"nick is not enough", irc.Prefix{Name: "admin-account", User: "u", Host: "h"}, "", "admin", false},
+	{"unknown role", irc.Prefix{Name: "someone", User: "u", Host: "h"}, "other-account", "nonexistent", false},
+}
+
+func TestHasRole(t *testing.T) {
+	for _, e := range roleTests {
+		prefix := e.prefix
+		msg := irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{"#testchan-1"},
+			Tags:    map[string]string{"account": e.account},
+			Prefix:  &prefix,
+		}
+		if r := hasRole(msg, e.role); r != e.has {
+			t.Errorf("%s: expected %v, got %v", e.desc, e.has, r)
+		}
+	}
+}
+
+func TestIdentifiedRole(t *testing.T) {
+	msg := irc.Message{
+		Command:  "PRIVMSG",
+		Params:   []string{"#testchan-1"},
+		Trailing: ":idlist",
+		Prefix:   &irc.Prefix{Name: "Identified", User: "u", Host: "h"},
+	}
+
+	var r []irc.Message
+	output := func(m irc.Message) { r = append(r, m) }
+
+	commandCallback("idlist")(context.Background(), output, msg)
+	if len(r) != 1 || r[0].Trailing != "access denied" {
+		t.Errorf("expected access to be denied, got %+v", r)
+	}
+
+	registerIdentification(output, irc.Message{
+		Command: "330",
+		Params:  []string{"gorepost", "identified", "admin-account"},
+	})
+	if hasRole(msg, "admin") {
+		t.Error("account registered without WHOIS user reply")
+	}
+
+	registerWhoisUser(output, irc.Message{
+		Command:  "311",
+		Params:   []string{"gorepost", "identified", "u", "h", "*"},
+		Trailing: "Identified User",
+	})
+	registerIdentification(output, irc.Message{
+		Command: "330",
+		Params:  []string{"gorepost", "identified", "admin-account"},
+	})
+	if !hasRole(msg, "admin") {
+		t.Error("expected identified user to have admin role")
+	}
+
+	spoofed := msg
+	spoofed.Prefix = &irc.Prefix{Name: "identified", User: "other", Host: "h"}
+	if hasRole(spoofed, "admin") {
+		t.Error("expected identification to be tied to user@host")
+	}
+
+	r = r[:0]
+	commandCallback("idlist")(context.Background(), output, msg)
+	if len(r) != 1 || r[0].Trailing == "access denied" {
+		t.Errorf("expected a list of identified users, got %+v", r)
+	}
+
+	forgetIdentification(output, irc.Message{
+		Command: "NICK",
+		Params:  []string{"other"},
+		Prefix:  &irc.Prefix{Name: "identified"},
+	})
+	if hasRole(msg, "admin") {
+		t.Error("expected identification to be forgotten after nick change")
+	}
+
+	registerWhoisUser(output, irc.Message{
+		Command: "311",
+		Params:  []string{"gorepost", "identified", "u", "h", "*"},
+	})
+	registerIdentification(output, irc.Message{
+		Command: "330",
+		Params:  []string{"gorepost", "identified", "admin-account"},
+	})
+	resetIdentification(output, irc.Message{Command: "001", Params: []string{"gorepost"}})
+	if hasRole(msg, "admin") {
+		t.Error("expected identification to be forgotten after reconnect")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Controller manages connections to all networks. It's implemented by the
+// program running the bot, for admin commands that reach beyond a single
+// connection.
+type Controller interface {
+	// Reload reloads configuration, and returns a summary of changes.
+	Reload() string
+	// Quit disconnects from all networks, and stops the bot.
+	Quit(message string)
+	// Status describes connection to every network.
+	Status() []string
+}
+
+var controller Controller
+
+var errNoController = errors.New("not available")
+
+// SetController sets Controller used by admin commands.
+func SetController(c Controller) {
+	initLock.Lock()
+	defer initLock.Unlock()
+	controller = c
+}
+
+func getController() Controller {
+	initLock.Lock()
+	defer initLock.Unlock()
+	return controller
+}
+
+// networkOutput returns a function sending messages to network, or output, if
+// network is empty or it's the one msg came from. Lets admin commands given
+// on one network act on another.
+func networkOutput(output func(irc.Message), msg irc.Message, network string) (func(irc.Message), error) {
+	if network == "" || network == msg.Context["Network"] {
+		return output, nil
+	}
+	conn := irc.GetConnection(network)
+	if conn == nil {
+		return nil, errors.New("not connected to " + network)
+	}
+	return conn.Sender, nil
+}
+
+func adminLog(msg irc.Message, args ...interface{}) {
+	log.Println(append([]interface{}{"Context:", msg.Context, "admin:", msg.Prefix}, args...)...)
+}
+
+func adminJoin(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	send, err := networkOutput(output, msg, args[0])
+	if err != nil {
+		return err
+	}
+	adminLog(msg, "joining", args[1:], "on", args[0])
+	send(irc.Message{
+		Command: "JOIN",
+		Params:  args[1:],
+	})
+	return nil
+}
+
+func adminPart(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	send, err := networkOutput(output, msg, args[0])
+	if err != nil {
+		return err
+	}
+	var reason string
+	if len(args) > 2 {
+		reason = args[2]
+	}
+	adminLog(msg, "parting", args[1], "on", args[0])
+	send(irc.Message{
+		Command:  "PART",
+		Params:   []string{args[1]},
+		Trailing: reason,
+	})
+	return nil
+}
+
+func adminSay(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	send, err := networkOutput(output, msg, args[0])
+	if err != nil {
+		return err
+	}
+	adminLog(msg, "saying to", args[1], "on", args[0]+":", args[2])
+	send(irc.Message{
+		Command:  "PRIVMSG",
+		Params:   []string{args[1]},
+		Trailing: args[2],
+	})
+	return nil
+}
+
+func adminNick(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	send, err := networkOutput(output, msg, args[0])
+	if err != nil {
+		return err
+	}
+	adminLog(msg, "changing nick to", args[1], "on", args[0])
+	// Otherwise we'd switch back to configured nick, once we notice it's free.
+	network := args[0]
+	if network == "" {
+		network = msg.Context["Network"]
+	}
+	if conn := irc.GetConnection(network); conn != nil {
+		conn.SetPrimaryNick(args[1])
+	}
+	send(irc.Message{
+		Command: "NICK",
+		Params:  []string{args[1]},
+	})
+	return nil
+}
+
+func adminRaw(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	send, err := networkOutput(output, msg, args[0])
+	if err != nil {
+		return err
+	}
+	m, err := irc.ParseMessage(args[1])
+	if err != nil {
+		return err
+	}
+	adminLog(msg, "sending raw line on", args[0]+":", args[1])
+	send(*m)
+	return nil
+}
+
+func adminReconnect(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	network := args[0]
+	if network == "" {
+		network = msg.Context["Network"]
+	}
+	conn := irc.GetConnection(network)
+	if conn == nil {
+		return errors.New("not connected to " + network)
+	}
+
+	var message string
+	if len(args) > 1 {
+		message = args[1]
+	}
+	adminLog(msg, "reconnecting to", network)
+	if network != msg.Context["Network"] {
+		output(reply(msg, "reconnecting to "+network))
+	}
+	go conn.Reconnect(message)
+	return nil
+}
+
+func adminReload(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	c := getController()
+	if c == nil {
+		return errNoController
+	}
+	adminLog(msg, "reloading configuration")
+	output(reply(msg, c.Reload()))
+	return nil
+}
+
+func adminQuit(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	c := getController()
+	if c == nil {
+		return errNoController
+	}
+	var message string
+	if len(args) > 0 {
+		message = args[0]
+	}
+	adminLog(msg, "quitting")
+	c.Quit(message)
+	return nil
+}
+
+func adminStatus(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	c := getController()
+	if c == nil {
+		return errNoController
+	}
+	output(privateNotice(msg, strings.Join(c.Status(), "; ")))
+	return nil
+}
+
+func adminPlugins(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	output(privateNotice(msg, strings.Join(append(pluginReport(), panicReport()...), "; ")))
+	return nil
+}
+
+func adminEnable(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	if !resetPanics(args[0]) {
+		return errors.New(args[0] + " didn't panic")
+	}
+	adminLog(msg, "enabling", args[0])
+	output(reply(msg, args[0]+" enabled"))
+	return nil
+}
+
+func init() {
+	for _, c := range []*command{
+		{name: "join", args: "[@network] <channel> [key]", description: "Joins a channel", run: adminJoin},
+		{name: "part", args: "[@network] <channel> [reason...]", description: "Leaves a channel", run: adminPart},
+		{name: "say", args: "[@network] <target> <text...>", description: "Sends a message to a channel, or user", run: adminSay},
+		{name: "nick", args: "[@network] <nick>", description: "Changes nick", run: adminNick},
+		{name: "raw", args: "[@network] <line...>", description: "Sends a raw IRC line", run: adminRaw},
+		{name: "reconnect", args: "[@network] [message...]", description: "Reconnects to a network", run: adminReconnect},
+		{name: "reload", description: "Reloads configuration", run: adminReload},
+		{name: "quit", args: "[message...]", description: "Disconnects from all networks, and stops the bot", run: adminQuit},
+		{name: "status", description: "Shows state of connections to all networks", run: adminStatus},
+		{name: "plugins", description: "Shows state of plugins", run: adminPlugins},
+		{name: "enable", args: "<plugin>", description: "Enables a plugin disabled for panicking", run: adminEnable},
+	} {
+		c.plugin = "admin"
+		c.role = adminRole
+		addCommand(c)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+type testController struct {
+	l        sync.Mutex
+	reloaded int
+	quit     []string
+}
+
+func (c *testController) Reload() string {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.reloaded++
+	return "reload: no changes"
+}
+
+func (c *testController) Quit(message string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.quit = append(c.quit, message)
+}
+
+func (c *testController) Status() []string {
+	return []string{"TestNet: connected"}
+}
+
+var adminTests = []struct {
+	trailing string
+	account  string
+	out      []irc.Message
+}{
+	{":join #foo", "other-account", []irc.Message{
+		{Command: "PRIVMSG", Params: []string{"#testchan-1"}, Trailing: "access denied"},
+	}},
+	{":join #foo", "admin-account", []irc.Message{
+		{Command: "JOIN", Params: []string{"#foo"}},
+	}},
+	{":join #foo key", "admin-account", []irc.Message{
+		{Command: "JOIN", Params: []string{"#foo", "key"}},
+	}},
+	{":join @nonexistent #foo", "admin-account", []irc.Message{
+		{Command: "PRIVMSG", Params: []string{"#testchan-1"}, Trailing: "error:not connected to nonexistent"},
+	}},
+	{":part #foo bye now", "admin-account", []irc.Message{
+		{Command: "PART", Params: []string{"#foo"}, Trailing: "bye now"},
+	}},
+	{":say #foo hello there", "admin-account", []irc.Message{
+		{Command: "PRIVMSG", Params: []string{"#foo"}, Trailing: "hello there"},
+	}},
+	{":nick newnick", "admin-account", []irc.Message{
+		{Command: "NICK", Params: []string{"newnick"}},
+	}},
+	{":raw MODE #foo +o someone", "admin-account", []irc.Message{
+		{Command: "MODE", Params: []string{"#foo", "+o", "someone"}},
+	}},
+	{":reload", "admin-account", []irc.Message{
+		{Command: "PRIVMSG", Params: []string{"#testchan-1"}, Trailing: "reload: no changes"},
+	}},
+	{":status", "admin-account", []irc.Message{
+		{Command: "NOTICE", Params: []string{"someone"}, Trailing: "TestNet: connected"},
+	}},
+	{":quit see you", "admin-account", nil},
+}
+
+func TestAdminCommands(t *testing.T) {
+	c := &testController{}
+	SetController(c)
+	defer SetController(nil)
+
+	for _, e := range adminTests {
+		var r []irc.Message
+		msg := irc.Message{
+			Command:  "PRIVMSG",
+			Params:   []string{"#testchan-1"},
+			Trailing: e.trailing,
+			Tags:     map[string]string{"account": e.account},
+			Prefix:   &irc.Prefix{Name: "someone", User: "u", Host: "h"},
+		}
+		name, _, _ := parseInvocation(msg)
+		commandCallback(name)(context.Background(), func(m irc.Message) { r = append(r, m) }, msg)
+
+		if fmt.Sprintf("%+v", r) != fmt.Sprintf("%+v", e.out) {
+			t.Errorf("%s: expected %+v, got %+v", e.trailing, e.out, r)
+		}
+	}
+
+	if c.reloaded != 1 || len(c.quit) != 1 || c.quit[0] != "see you" {
+		t.Errorf("unexpected controller calls: %+v", c)
+	}
+}
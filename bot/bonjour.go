@@ -5,25 +5,21 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
-	"strings"
 	"time"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-func bonjour(output func(irc.Message), msg irc.Message) {
+func bonjour(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var rmsg string
 
-	if strings.Split(msg.Trailing, " ")[0] != ":bonjour" {
-		return
-	}
-
 	t, _ := time.Parse("2006-01-02", "2015-12-01")
 	max := int(time.Now().Sub(t).Hours())/24 + 1
 
-	img, err := httpGetXpath("http://ditesbonjouralamadame.tumblr.com/page/"+fmt.Sprintf("%d", rand.Intn(max)+1), "//div[@class='photo post']//a/@href")
+	img, err := httpGetXpath(ctx, "http://ditesbonjouralamadame.tumblr.com/page/"+fmt.Sprintf("%d", rand.Intn(max)+1), "//div[@class='photo post']//a/@href")
 	if err != nil {
 		rmsg = fmt.Sprint("error:", err)
 	} else {
@@ -31,9 +27,14 @@ func bonjour(output func(irc.Message), msg irc.Message) {
 	}
 
 	output(reply(msg, rmsg))
+	return nil
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-	addCallback("PRIVMSG", "bonjour", bonjour)
+	addCommand(&command{
+		name:        "bonjour",
+		description: "Links a random nsfw picture from \"dites bonjour à la madame\"",
+		run:         bonjour,
+	})
 }
@@ -1,25 +1,54 @@
 package bot
 
 import (
+	"context"
 	"github.com/arachnist/dyncfg"
+	"log"
 	"sync"
+	"time"
 )
 
 var cfg *dyncfg.Dyncfg
 var initLock sync.Mutex
-var initList []func()
+var running sync.WaitGroup
 
-func addInit(f func()) {
-	initLock.Lock()
-	defer initLock.Unlock()
+// Initialize sets up the store, and initializes plugins registered with
+// addPlugin.
+func Initialize(config *dyncfg.Dyncfg) {
+	cfg = config
+	store = openStore()
 
-	initList = append(initList, f)
+	for _, s := range registeredPlugins() {
+		initPlugin(context.Background(), s)
+	}
 }
 
-func Initialize(config *dyncfg.Dyncfg) {
-	cfg = config
+// Shutdown waits up to timeout for running callbacks to finish, and cleans up
+// after plugins. Callbacks still running by then are cancelled, and false is
+// returned.
+func Shutdown(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
 
-	for _, f := range initList {
-		f()
+	clean := true
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("timed out waiting for callbacks to finish")
+		cancelCallbacks()
+		clean = false
 	}
+
+	closePlugins()
+
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Println("error closing store:", err)
+		}
+	}
+
+	return clean
 }
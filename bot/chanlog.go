@@ -0,0 +1,300 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arachnist/dyncfg"
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Layout of log files under "ChannelLogDir", if "ChannelLogLayout" isn't
+// configured. Same as used by ZNC.
+const defaultChannelLogLayout = "$network/$channel/$date.log"
+
+// How many messages can wait to be written, before new ones are dropped.
+const chanlogQueueSize = 1024
+
+// chanlogLine is a line of channel's log.
+type chanlogLine struct {
+	channel string
+	text    string
+}
+
+// chanlogEntry is a message waiting to be logged.
+type chanlogEntry struct {
+	msg irc.Message
+	now time.Time
+}
+
+// chanlogPlugin writes logs of channels we're on, one file per channel per
+// day, in the format of ZNC's log module. It's run as a filter, so lines are
+// written in the order messages came in, but it never stops a message.
+// Messages are written by a goroutine of its own, so slow disks don't hold up
+// the connection.
+type chanlogPlugin struct {
+	l       sync.Mutex
+	active  bool
+	queue   chan chanlogEntry
+	written chan struct{} // closed when writer is done with the queue
+	day     string
+	files   map[string]*os.File // open files of current day, by path
+	err     error               // why the last write failed, if it did
+}
+
+var chanlog = &chanlogPlugin{}
+
+func (p *chanlogPlugin) Name() string {
+	return "chanlog"
+}
+
+func (p *chanlogPlugin) Init(ctx context.Context, cfg *dyncfg.Dyncfg) error {
+	p.l.Lock()
+	if p.queue == nil {
+		p.queue = make(chan chanlogEntry, chanlogQueueSize)
+		p.written = make(chan struct{})
+		go p.writer(p.queue, p.written)
+	}
+	p.active = true
+	p.files = make(map[string]*os.File)
+	p.err = nil
+	p.l.Unlock()
+
+	addFilter(p.Name(), -100, p.filter)
+	return nil
+}
+
+// Close waits for queued messages to be written, and closes log files.
+func (p *chanlogPlugin) Close() error {
+	p.l.Lock()
+	p.active = false
+	queue, written := p.queue, p.written
+	p.queue, p.written = nil, nil
+	p.l.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-written
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+	return p.closeFiles()
+}
+
+func (p *chanlogPlugin) Health() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return p.err
+}
+
+// closeFiles has to be called with p.l held.
+func (p *chanlogPlugin) closeFiles() error {
+	var err error
+	for path, f := range p.files {
+		if e := f.Close(); e != nil {
+			err = e
+		}
+		delete(p.files, path)
+	}
+	return err
+}
+
+func (p *chanlogPlugin) filter(e *Event) verdict {
+	p.enqueue(e.Message)
+	return pass
+}
+
+// enqueue passes msg on to writer. If writer can't keep up, msg is dropped.
+func (p *chanlogPlugin) enqueue(msg irc.Message) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if !p.active {
+		return
+	}
+	select {
+	case p.queue <- chanlogEntry{msg, time.Now()}:
+	default:
+		log.Println("Context:", msg.Context, "chanlog: queue full, dropping", msg.Command)
+	}
+}
+
+// writer logs queued messages, until queue is closed.
+func (p *chanlogPlugin) writer(queue <-chan chanlogEntry, written chan<- struct{}) {
+	defer close(written)
+	for e := range queue {
+		p.log(e.msg, e.now)
+	}
+}
+
+// LogSent is called by connections with messages we send, so our own
+// messages show up in channel logs. Everything else we do, the server echoes
+// back.
+func LogSent(msg irc.Message) {
+	if msg.Command == "PRIVMSG" || msg.Command == "NOTICE" {
+		chanlog.enqueue(msg)
+	}
+}
+
+// chanlogLines returns lines msg adds to channel logs. "Channels" context is
+// used for QUIT and NICK, which don't name any channels.
+func chanlogLines(msg irc.Message) []chanlogLine {
+	if msg.Prefix == nil {
+		return nil
+	}
+	server := isupport(msg.Context["Network"])
+	nick := msg.Prefix.Name
+	user := fmt.Sprintf("%s (%s@%s)", nick, msg.Prefix.User, msg.Prefix.Host)
+
+	params := msg.Params
+	if msg.Trailing != "" || msg.EmptyTrailing {
+		params = append(params[:len(params):len(params)], msg.Trailing)
+	}
+	arg := func(i int) string {
+		if i < len(params) {
+			return params[i]
+		}
+		return ""
+	}
+	one := func(text string) []chanlogLine {
+		if !server.IsChannel(arg(0)) {
+			return nil
+		}
+		return []chanlogLine{{arg(0), text}}
+	}
+	all := func(text string) []chanlogLine {
+		var r []chanlogLine
+		for _, channel := range strings.Split(msg.Context["Channels"], ",") {
+			if channel != "" {
+				r = append(r, chanlogLine{channel, text})
+			}
+		}
+		return r
+	}
+
+	switch msg.Command {
+	case "PRIVMSG", "NOTICE":
+		// CTCP requests and replies, other than ACTION, aren't logged.
+		text := arg(1)
+		switch {
+		case strings.HasPrefix(text, "\x01ACTION "):
+			return one("* " + nick + " " + strings.TrimSuffix(text[len("\x01ACTION "):], "\x01"))
+		case strings.HasPrefix(text, "\x01"):
+			return nil
+		case msg.Command == "NOTICE":
+			return one("-" + nick + "- " + text)
+		}
+		return one("<" + nick + "> " + text)
+	case "JOIN":
+		return one("*** Joins: " + user)
+	case "PART":
+		return one("*** Parts: " + user + " (" + arg(1) + ")")
+	case "KICK":
+		return one("*** " + arg(1) + " was kicked by " + nick + " (" + arg(2) + ")")
+	case "TOPIC":
+		return one("*** " + nick + " changes topic to '" + arg(1) + "'")
+	case "MODE":
+		if len(params) < 2 {
+			return nil
+		}
+		return one("*** " + nick + " sets mode: " + strings.Join(params[1:], " "))
+	case "QUIT":
+		return all("*** Quits: " + user + " (" + arg(0) + ")")
+	case "NICK":
+		return all("*** " + nick + " is now known as " + arg(0))
+	}
+	return nil
+}
+
+// chanlogExcluded tells if channel matches one of "ChannelLogExclude" masks.
+func chanlogExcluded(context map[string]string, channel string) bool {
+	server := isupport(context["Network"])
+	for _, mask := range cfg.LookupStringSlice(context, "ChannelLogExclude") {
+		if maskMatch(server.Fold(mask), server.Fold(channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// chanlogPath returns path of log file, relative to "ChannelLogDir", filling
+// $network, $channel and $date in layout.
+func chanlogPath(layout, network, channel string, now time.Time) string {
+	clean := strings.NewReplacer("/", "_", "\\", "_", "\x00", "_")
+	return strings.NewReplacer(
+		"$network", clean.Replace(network),
+		"$channel", clean.Replace(isupport(network).Fold(channel)),
+		"$date", now.Format("2006-01-02"),
+	).Replace(layout)
+}
+
+// log writes msg, received at now, to logs of channels it concerns, unless
+// they're excluded, or "ChannelLogDir" isn't configured for them.
+func (p *chanlogPlugin) log(msg irc.Message, now time.Time) {
+	network := msg.Context["Network"]
+	for _, l := range chanlogLines(msg) {
+		context := map[string]string{"Network": network, "Target": l.channel}
+		if !pluginEnabled(context, p.Name()) || chanlogExcluded(context, l.channel) {
+			continue
+		}
+		dir := cfg.LookupString(context, "ChannelLogDir")
+		if dir == "" {
+			continue
+		}
+		layout := cfg.LookupString(context, "ChannelLogLayout")
+		if layout == "" {
+			layout = defaultChannelLogLayout
+		}
+
+		path := filepath.Join(dir, chanlogPath(layout, network, l.channel, now))
+		if err := p.write(path, now, l.text); err != nil {
+			log.Println("Context:", msg.Context, "chanlog: error writing", path, err)
+		}
+	}
+}
+
+// write appends a line to file at path. Files are kept open until the day
+// changes, or the plugin is closed.
+func (p *chanlogPlugin) write(path string, now time.Time, text string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if day := now.Format("2006-01-02"); day != p.day {
+		p.closeFiles()
+		p.day = day
+	}
+
+	f, ok := p.files[path]
+	if !ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			p.err = err
+			return err
+		}
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			p.err = err
+			return err
+		}
+		p.files[path] = f
+	}
+
+	_, err := fmt.Fprintf(f, "[%s] %s\n", now.Format("15:04:05"), text)
+	p.err = err
+	return err
+}
+
+func init() {
+	addPlugin(chanlog)
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+var chanlogTests = []struct {
+	raw      string
+	channels string
+	lines    []chanlogLine
+}{
+	{":nick!user@host PRIVMSG #chan :hello there", "", []chanlogLine{{"#chan", "<nick> hello there"}}},
+	{":nick!user@host PRIVMSG #chan :\x01ACTION waves\x01", "", []chanlogLine{{"#chan", "* nick waves"}}},
+	{":nick!user@host PRIVMSG #chan :\x01VERSION\x01", "", nil},
+	{":nick!user@host PRIVMSG gorepost :hello", "", nil},
+	{":nick!user@host NOTICE #chan :psst", "", []chanlogLine{{"#chan", "-nick- psst"}}},
+	{":nick!user@host NOTICE #chan :\x01VERSION gorepost\x01", "", nil},
+	{":nick!user@host JOIN #chan", "", []chanlogLine{{"#chan", "*** Joins: nick (user@host)"}}},
+	{":nick!user@host JOIN :#chan", "", []chanlogLine{{"#chan", "*** Joins: nick (user@host)"}}},
+	{":nick!user@host PART #chan :bye", "", []chanlogLine{{"#chan", "*** Parts: nick (user@host) (bye)"}}},
+	{":op!user@host KICK #chan nick :behave", "", []chanlogLine{{"#chan", "*** nick was kicked by op (behave)"}}},
+	{":op!user@host TOPIC #chan :new topic", "", []chanlogLine{{"#chan", "*** op changes topic to 'new topic'"}}},
+	{":op!user@host MODE #chan +o nick", "", []chanlogLine{{"#chan", "*** op sets mode: +o nick"}}},
+	{":op!user@host MODE op +i", "", nil},
+	{":nick!user@host QUIT :gone", "#a,#b", []chanlogLine{
+		{"#a", "*** Quits: nick (user@host) (gone)"},
+		{"#b", "*** Quits: nick (user@host) (gone)"},
+	}},
+	{":nick!user@host NICK newnick", "#a", []chanlogLine{{"#a", "*** nick is now known as newnick"}}},
+	{"PING :irc.test", "", nil},
+}
+
+func TestChanlogLines(t *testing.T) {
+	for _, e := range chanlogTests {
+		msg, err := irc.ParseMessage(e.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Context = map[string]string{"Network": "TestNet", "Channels": e.channels}
+
+		if lines := chanlogLines(*msg); !reflect.DeepEqual(lines, e.lines) {
+			t.Errorf("%q: expected %q, got %q", e.raw, e.lines, lines)
+		}
+	}
+}
+
+func TestChanlogWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-chanlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &chanlogPlugin{active: true, files: make(map[string]*os.File)}
+	defer p.Close()
+
+	day := time.Date(2015, 6, 1, 23, 59, 58, 0, time.Local)
+	first := filepath.Join(dir, chanlogPath(defaultChannelLogLayout, "TestNet", "#Chan/x", day))
+	if first != filepath.Join(dir, "TestNet", "#chan_x", "2015-06-01.log") {
+		t.Errorf("unexpected path %s", first)
+	}
+
+	next := day.Add(3 * time.Second)
+	second := filepath.Join(dir, chanlogPath(defaultChannelLogLayout, "TestNet", "#chan", next))
+	for _, w := range []struct {
+		path string
+		now  time.Time
+		text string
+	}{
+		{first, day, "<nick> one"},
+		{first, day.Add(time.Second), "* nick two"},
+		{second, next, "<nick> three"},
+	} {
+		if err := p.write(w.path, w.now, w.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(p.files) != 1 {
+		t.Errorf("files of previous day still open: %v", p.files)
+	}
+	for path, expected := range map[string]string{
+		first:  "[23:59:58] <nick> one\n[23:59:59] * nick two\n",
+		second: "[00:00:01] <nick> three\n",
+	} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, b)
+		}
+	}
+	if p.Health() != nil {
+		t.Error("unexpected error:", p.Health())
+	}
+}
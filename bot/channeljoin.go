@@ -11,7 +11,8 @@ import (
 )
 
 // channeljoin joins configured IRC channels when IRC server confirms we're good
-// to go.
+// to go. If we've already logged in using SASL, secured channels are joined as
+// well, without waiting for NickServ.
 func channeljoin(output func(irc.Message), msg irc.Message) {
 	for _, channel := range cfg.LookupStringSlice(msg.Context, "Channels") {
 		log.Println(msg.Context["Network"], "joining channel", channel)
@@ -20,6 +21,10 @@ func channeljoin(output func(irc.Message), msg irc.Message) {
 			Params:  []string{channel},
 		})
 	}
+
+	if saslLoggedIn(msg.Context["Network"]) {
+		joinSecuredChannels(output, msg.Context)
+	}
 }
 
 func init() {
@@ -5,6 +5,7 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -25,26 +26,20 @@ type checkinator struct {
 	Users   []user
 }
 
-func at(output func(irc.Message), msg irc.Message) {
+func at(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var rmsg string
 	var values checkinator
 	var now []string
 	var recently []string
 
-	if strings.Split(msg.Trailing, " ")[0] != ":at" {
-		return
-	}
-
-	data, err := httpGet("https://at.hackerspace.pl/api")
+	data, err := httpGet(ctx, "https://at.hackerspace.pl/api")
 	if err != nil {
-		output(reply(msg, fmt.Sprint("error:", err)))
-		return
+		return err
 	}
 
 	err = json.Unmarshal(data, &values)
 	if err != nil {
-		output(reply(msg, fmt.Sprint("error:", err)))
-		return
+		return err
 	}
 
 	rmsg = "at:"
@@ -77,8 +72,13 @@ func at(output func(irc.Message), msg irc.Message) {
 	}
 
 	output(reply(msg, rmsg))
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "at", at)
+	addCommand(&command{
+		name:        "at",
+		description: "Lists people at the hackerspace",
+		run:         at,
+	})
 }
@@ -0,0 +1,284 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Prefix commands start with, used when "CommandPrefix" isn't configured.
+const defaultCommandPrefix = ":"
+
+// errUsage can be returned by command handlers, to have usage text sent back.
+var errUsage = errors.New("usage error")
+
+// command describes a bot command, like ":roll 6", or "gorepost: roll 6".
+type command struct {
+	name    string
+	aliases []string
+	// plugin is the name used in "DisabledPlugins" and "WhitelistedPlugins".
+	// Defaults to name.
+	plugin string
+	// args describes arguments, separated by spaces: "<name>" is required,
+	// "[name]" is optional, and "<name...>" or "[name...]" takes the rest
+	// of the line as is. Other arguments can be quoted, to include spaces.
+	// "[@name]" is taken only if the argument starts with @, and passed
+	// without it; if it's missing, an empty string is passed in its place.
+	args string
+	// usage is sent back on usage errors. Defaults to args.
+	usage string
+	// description is a short summary of what the command does, for :help.
+	description string
+	// role is required to run the command, if set. See hasRole.
+	role string
+	run  func(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error
+
+	spec []argSpec
+}
+
+type argSpec struct {
+	name     string
+	optional bool
+	rest     bool
+	at       bool
+}
+
+var commands = make(map[string]*command)
+var commandLock sync.RWMutex
+
+// addCommand registers a command, and a PRIVMSG callback running commands of
+// its plugin.
+func addCommand(c *command) {
+	c.spec = parseArgSpec(c.args)
+	if c.plugin == "" {
+		c.plugin = c.name
+	}
+
+	commandLock.Lock()
+	for _, name := range append([]string{c.name}, c.aliases...) {
+		if old, ok := commands[name]; ok {
+			log.Println("command", name, "of", c.plugin, "overrides one of", old.plugin)
+		}
+		commands[name] = c
+	}
+	commandLock.Unlock()
+
+	addContextCallback("PRIVMSG", c.plugin, pluginCallback(c.plugin))
+}
+
+// pluginCallback returns a callback running commands of plugin.
+func pluginCallback(plugin string) callback {
+	return func(ctx context.Context, output func(irc.Message), msg irc.Message) {
+		name, _, ok := parseInvocation(msg)
+		if !ok {
+			return
+		}
+		if c := lookupCommand(name); c != nil && c.plugin == plugin {
+			c.callback(ctx, output, msg)
+		}
+	}
+}
+
+// lookupCommand returns command registered under name or alias, or nil.
+func lookupCommand(name string) *command {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+	return commands[strings.ToLower(name)]
+}
+
+// commandNames returns sorted names of registered commands, without aliases.
+func commandNames() []string {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+
+	var r []string
+	for name, c := range commands {
+		if name == c.name {
+			r = append(r, name)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func parseArgSpec(s string) []argSpec {
+	var r []argSpec
+	for _, f := range strings.Fields(s) {
+		var a argSpec
+		if strings.HasPrefix(f, "[") {
+			a.optional = true
+		}
+		a.name = strings.Trim(f, "<>[]")
+		if a.optional && strings.HasPrefix(a.name, "@") {
+			a.at = true
+			a.name = a.name[1:]
+		}
+		if strings.HasSuffix(a.name, "...") {
+			a.rest = true
+			a.name = strings.TrimSuffix(a.name, "...")
+		}
+		r = append(r, a)
+	}
+	return r
+}
+
+// commandPrefix returns "CommandPrefix" configured for context.
+func commandPrefix(context map[string]string) string {
+	if p := cfg.LookupString(context, "CommandPrefix"); p != "" {
+		return p
+	}
+	return defaultCommandPrefix
+}
+
+// parseInvocation checks if msg invokes a command, either with the command
+// prefix, or by addressing us by nick. Returns lowercased command name, and
+// the rest of the line.
+func parseInvocation(msg irc.Message) (name, line string, ok bool) {
+	text := msg.Trailing
+	prefix := commandPrefix(msg.Context)
+	nick := currentNick(msg.Context)
+
+	switch {
+	case strings.HasPrefix(text, prefix):
+		text = text[len(prefix):]
+	case nick != "" && len(text) > len(nick) &&
+		isupport(msg.Context["Network"]).EqualFold(text[:len(nick)], nick) &&
+		(text[len(nick)] == ':' || text[len(nick)] == ','):
+		text = strings.TrimLeft(text[len(nick)+1:], " ")
+		text = strings.TrimPrefix(text, prefix)
+	default:
+		return "", "", false
+	}
+
+	if i := strings.Index(text, " "); i >= 0 {
+		name, line = text[:i], text[i+1:]
+	} else {
+		name = text
+	}
+	if name == "" {
+		return "", "", false
+	}
+	return strings.ToLower(name), line, true
+}
+
+// nextArg splits the first argument off line. Arguments can be quoted with
+// double quotes, in which backslash escapes the next character, or with
+// single quotes.
+func nextArg(line string) (arg, rest string, err error) {
+	line = strings.TrimLeft(line, " ")
+	if line == "" {
+		return "", "", nil
+	}
+
+	quote := line[0]
+	if quote != '"' && quote != '\'' {
+		if i := strings.Index(line, " "); i >= 0 {
+			return line[:i], line[i+1:], nil
+		}
+		return line, "", nil
+	}
+
+	var b []byte
+	for i := 1; i < len(line); i++ {
+		switch {
+		case line[i] == quote:
+			if i+1 < len(line) && line[i+1] != ' ' {
+				return "", "", errors.New("missing space after closing quote")
+			}
+			return string(b), line[i+1:], nil
+		case line[i] == '\\' && quote == '"' && i+1 < len(line):
+			i++
+			b = append(b, line[i])
+		default:
+			b = append(b, line[i])
+		}
+	}
+	return "", "", errors.New("unterminated quote")
+}
+
+// parseArgs splits line into arguments according to command's spec.
+func (c *command) parseArgs(line string) ([]string, error) {
+	var args []string
+	for _, a := range c.spec {
+		line = strings.TrimLeft(line, " ")
+		if a.at {
+			var arg string
+			if strings.HasPrefix(line, "@") {
+				arg, line, _ = nextArg(line)
+			}
+			args = append(args, strings.TrimPrefix(arg, "@"))
+			continue
+		}
+		if line == "" {
+			if !a.optional {
+				return nil, errUsage
+			}
+			break
+		}
+		if a.rest {
+			args = append(args, strings.TrimRight(line, " "))
+			line = ""
+			break
+		}
+
+		var arg string
+		var err error
+		arg, line, err = nextArg(line)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if strings.TrimSpace(line) != "" {
+		return nil, errUsage
+	}
+	return args, nil
+}
+
+// usageText returns usage message for command, with prefix used in context.
+func (c *command) usageText(context map[string]string) string {
+	usage := c.usage
+	if usage == "" {
+		usage = c.args
+	}
+	return strings.TrimSpace("Usage: " + commandPrefix(context) + c.name + " " + usage)
+}
+
+// callback runs the command if msg invokes it, and its author has the
+// required role. Replies with usage text if arguments don't match, or with the
+// error returned by the handler.
+func (c *command) callback(ctx context.Context, output func(irc.Message), msg irc.Message) {
+	name, line, ok := parseInvocation(msg)
+	if !ok || lookupCommand(name) != c {
+		return
+	}
+
+	if c.role != "" && !hasRole(msg, c.role) {
+		log.Println("Context:", msg.Context, "Permission denied:", msg.Prefix, "needs role", c.role, "to run", c.name)
+		output(reply(msg, "access denied"))
+		return
+	}
+
+	args, err := c.parseArgs(line)
+	if err == nil {
+		err = c.run(ctx, output, msg, args)
+	}
+
+	switch {
+	case err == errUsage:
+		output(reply(msg, c.usageText(msg.Context)))
+	case err != nil:
+		output(reply(msg, fmt.Sprint("error:", err)))
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+var invocationTests = []struct {
+	trailing string
+	name     string
+	line     string
+	ok       bool
+}{
+	{":roll 6", "roll", "6", true},
+	{":ROLL 6 2", "roll", "6 2", true},
+	{":seen", "seen", "", true},
+	{"gorepost: roll 6", "roll", "6", true},
+	{"GorePost, :roll 6", "roll", "6", true},
+	{"gorepost:roll", "roll", "", true},
+	{"gorepostx: roll 6", "", "", false},
+	{"gorepost roll 6", "", "", false},
+	{"roll 6", "", "", false},
+	{":", "", "", false},
+	{"", "", "", false},
+}
+
+func TestParseInvocation(t *testing.T) {
+	for _, e := range invocationTests {
+		name, line, ok := parseInvocation(irc.Message{Trailing: e.trailing})
+		if name != e.name || line != e.line || ok != e.ok {
+			t.Errorf("%q: expected %q, %q, %v, got %q, %q, %v", e.trailing, e.name, e.line, e.ok, name, line, ok)
+		}
+	}
+}
+
+var argsTests = []struct {
+	spec string
+	line string
+	args []string
+	err  bool
+}{
+	{"<sides> [rolls]", "6", []string{"6"}, false},
+	{"<sides> [rolls]", "6 2", []string{"6", "2"}, false},
+	{"<sides> [rolls]", "  6   2 ", []string{"6", "2"}, false},
+	{"<sides> [rolls]", "", nil, true},
+	{"<sides> [rolls]", "6 2 1", nil, true},
+	{"<nick> <text...>", "foo bar  baz ", []string{"foo", "bar  baz"}, false},
+	{"<nick> <text...>", "foo", nil, true},
+	{"[text...]", "", nil, false},
+	{"<a> <b>", `"foo bar" baz`, []string{"foo bar", "baz"}, false},
+	{"<a> <b>", `'foo "bar"' "b\"a\\z"`, []string{`foo "bar"`, `b"a\z`}, false},
+	{"<a> <b>", `"" baz`, []string{"", "baz"}, false},
+	{"<a>", `"foo bar`, nil, true},
+	{"<a>", `"foo"bar`, nil, true},
+	{"", "", nil, false},
+	{"", "foo", nil, true},
+	{"[@network] <channel>", "#chan", []string{"", "#chan"}, false},
+	{"[@network] <channel>", "@net #chan", []string{"net", "#chan"}, false},
+	{"[@network] <channel>", "@net", nil, true},
+	{"[@network] [message...]", "", []string{""}, false},
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, e := range argsTests {
+		c := command{spec: parseArgSpec(e.spec)}
+		args, err := c.parseArgs(e.line)
+		if (err != nil) != e.err || fmt.Sprintf("%q", args) != fmt.Sprintf("%q", e.args) {
+			t.Errorf("%s: %q: expected %q, error %v, got %q, %v", e.spec, e.line, e.args, e.err, args, err)
+		}
+	}
+}
+
+func TestCommandAliases(t *testing.T) {
+	if c := lookupCommand("papież"); c == nil || c.name != "papiez" {
+		t.Errorf("expected papież to be an alias of papiez, got %+v", c)
+	}
+	if c := lookupCommand("g"); c == nil || c.plugin != "google" {
+		t.Errorf("expected g to be a command of google plugin, got %+v", c)
+	}
+	for _, name := range commandNames() {
+		if name == "papież" {
+			t.Error("aliases shouldn't be listed as commands")
+		}
+	}
+}
+
+var helpTests = []struct {
+	trailing string
+	command  string
+	text     string
+}{
+	{":help ping", "PRIVMSG", "^:ping: Checks if the bot is alive. Usage: :ping$"},
+	{":help :papież", "PRIVMSG", "^:papiez: .* Aliases: papież$"},
+	{":help nonexistent", "PRIVMSG", "^No such command: nonexistent$"},
+	{":help roll", "NOTICE", "^:roll: .* Usage: :roll <sides int>"},
+	{":help", "NOTICE", "^Commands: .*\\bhelp, .*\\broll, .*\\bseen\\b.* Use :help <command> for details$"},
+	{":commands", "NOTICE", "^Commands: "},
+}
+
+func TestHelp(t *testing.T) {
+	for _, e := range helpTests {
+		var r []irc.Message
+		help := lookupCommand("help")
+		help.callback(context.Background(), func(m irc.Message) { r = append(r, m) }, irc.Message{
+			Command:  "PRIVMSG",
+			Params:   []string{"#testchan-1"},
+			Trailing: e.trailing,
+			Prefix:   &irc.Prefix{Name: "idontexist"},
+		})
+
+		if len(r) != 1 {
+			t.Errorf("%s: expected a single reply, got %+v", e.trailing, r)
+			continue
+		}
+		target := "#testchan-1"
+		if e.command == "NOTICE" {
+			target = "idontexist"
+		}
+		if r[0].Command != e.command || r[0].Params[0] != target || !regexp.MustCompile(e.text).MatchString(r[0].Trailing) {
+			t.Errorf("%s: expected %s to %s matching %q, got %+v", e.trailing, e.command, target, e.text, r[0])
+		}
+	}
+}
@@ -5,23 +5,19 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"regexp"
-	"strings"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
 var stripCycki *regexp.Regexp
 
-func cycki(output func(irc.Message), msg irc.Message) {
+func cycki(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var rmsg string
 
-	if strings.Split(msg.Trailing, " ")[0] != ":cycki" {
-		return
-	}
-
-	img, err := httpGetXpath("http://oboobs.ru/random/", "//img/@src")
+	img, err := httpGetXpath(ctx, "http://oboobs.ru/random/", "//img/@src")
 	if err != nil {
 		rmsg = fmt.Sprint("error:", err)
 	} else {
@@ -29,9 +25,14 @@ func cycki(output func(irc.Message), msg irc.Message) {
 	}
 
 	output(reply(msg, rmsg))
+	return nil
 }
 
 func init() {
 	stripCycki, _ = regexp.Compile("_preview")
-	addCallback("PRIVMSG", "cycki", cycki)
+	addCommand(&command{
+		name:        "cycki",
+		description: "Links a random nsfw picture",
+		run:         cycki,
+	})
 }
@@ -5,60 +5,277 @@
 package bot
 
 import (
+	"context"
 	"log"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-var callbacks = make(map[string]map[string]func(func(irc.Message), irc.Message))
+// How long callbacks get to run, if "CallbackTimeout" isn't configured.
+const defaultCallbackTimeout = 30 * time.Second
+
+// callback is called by Dispatcher with messages it was registered for. ctx is
+// cancelled when callback runs out of time, or the bot shuts down, so
+// plugins talking to slow services can give up. New plugins should rather use
+// eventCallback.
+type callback func(ctx context.Context, output func(irc.Message), msg irc.Message)
+
+// verdict is returned by filters and ordered callbacks, to tell Dispatcher
+// whether to pass the message on.
+type verdict int
+
+const (
+	// pass lets the message through to callbacks that follow.
+	pass verdict = iota
+	// stop keeps the message from callbacks that follow.
+	stop
+)
+
+// orderedCallback is run in order of priority, one at a time, before
+// callbacks registered with addCallback, and can keep the message from them.
+type orderedCallback func(e *Event) verdict
+
+// filter is run by Dispatcher on every message, in order of priority, before
+// any callbacks. Filters hold up the connection, so they have to be quick.
+type filter func(e *Event) verdict
+
+// registration is a callback, or filter, registered under name. Exactly one
+// of f, ordered and filter is set.
+type registration struct {
+	name     string
+	priority int
+	f        eventCallback
+	ordered  orderedCallback
+	filter   filter
+}
+
+// Registrations are kept sorted by priority, then name, so they run in the
+// same order every time. Lower priority goes first.
+var callbacks = make(map[string][]*registration)
+var filters []*registration
 var callbackLock sync.RWMutex
 
+// Parent of contexts passed to callbacks, cancelled by Shutdown.
+var callbackContext, cancelCallbacks = context.WithCancel(context.Background())
+
 // addCallback registers callbacks that can be later dispatched by Dispatcher
-func addCallback(command, name string, callback func(func(irc.Message), irc.Message)) {
+func addCallback(command, name string, f func(func(irc.Message), irc.Message)) {
+	addEventCallback(command, name, func(e *Event) {
+		f(e.output, e.Message)
+	})
+}
+
+// addContextCallback registers a callback that wants to know when to give up.
+// Such callbacks run concurrently, in no particular order.
+func addContextCallback(command, name string, f callback) {
+	addEventCallback(command, name, func(e *Event) {
+		f(e.Ctx, e.output, e.Message)
+	})
+}
+
+// addOrderedCallback registers a callback run in order of priority, which can
+// stop the message from reaching callbacks that follow it.
+func addOrderedCallback(command, name string, priority int, f orderedCallback) {
+	register(command, &registration{name: name, priority: priority, ordered: f})
+}
+
+// register adds r to callbacks for command.
+func register(command string, r *registration) {
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+	log.Println("adding callback", command, r.name)
+	command = strings.ToUpper(command)
+	callbacks[command] = insert(callbacks[command], r)
+}
+
+// addFilter registers a filter, run by Dispatcher on every message before it
+// reaches callbacks.
+func addFilter(name string, priority int, f filter) {
 	callbackLock.Lock()
 	defer callbackLock.Unlock()
-	log.Println("adding callback", command, name)
-	if _, ok := callbacks[command]; !ok {
-		callbacks[command] = make(map[string]func(func(irc.Message), irc.Message))
+	log.Println("adding filter", name)
+	filters = insert(filters, &registration{name: name, priority: priority, filter: f})
+}
+
+// insert returns a copy of list with r added in order, replacing one
+// registered under the same name.
+func insert(list []*registration, r *registration) []*registration {
+	var l []*registration
+	for _, old := range list {
+		if old.name != r.name {
+			l = append(l, old)
+		}
+	}
+	l = append(l, r)
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].priority != l[j].priority {
+			return l[i].priority < l[j].priority
+		}
+		return l[i].name < l[j].name
+	})
+	return l
+}
+
+// callbackNames returns sorted names callbacks are registered under. Those of
+// commands are names of their plugins.
+func callbackNames() []string {
+	callbackLock.RLock()
+	defer callbackLock.RUnlock()
+
+	seen := make(map[string]bool)
+	var r []string
+	for _, list := range callbacks {
+		for _, c := range list {
+			if !seen[c.name] {
+				seen[c.name] = true
+				r = append(r, c.name)
+			}
+		}
 	}
-	callbacks[strings.ToUpper(command)][name] = callback
+	sort.Strings(r)
+	return r
+}
+
+// callbackTimeout returns "CallbackTimeout", in seconds, for context.
+func callbackTimeout(context map[string]string) time.Duration {
+	if t := cfg.LookupInt(context, "CallbackTimeout"); t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	return defaultCallbackTimeout
+}
+
+// guard calls f with a context that runs out after callback timeout. Panics
+// are recovered, so a broken plugin doesn't take the whole bot down, and
+// counted, so it can be disabled if it keeps panicking.
+func guard(plugin string, input irc.Message, f func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(callbackContext, callbackTimeout(input.Context))
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Context:", input.Context, "Plugin", plugin, "panicked:", r, "\n"+string(debug.Stack()))
+			recordPanic(input.Context, plugin)
+		}
+	}()
+
+	f(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Println("Context:", input.Context, "Plugin", plugin, "ran out of time")
+	}
+}
+
+// run runs a callback of plugin in its own goroutine, keeping track of it for
+// Shutdown.
+func run(plugin string, f eventCallback, output func(irc.Message), input irc.Message) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		guard(plugin, input, func(ctx context.Context) {
+			f(newEvent(ctx, output, input))
+		})
+	}()
+}
+
+// runOrdered runs ordered callbacks one by one, in their own goroutine, and
+// then the rest of callbacks, unless one of the ordered ones stops the
+// message. A panicking callback lets the message through.
+func runOrdered(ordered, rest []*registration, output func(irc.Message), input irc.Message) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		for _, r := range ordered {
+			v := pass
+			guard(r.name, input, func(ctx context.Context) {
+				v = r.ordered(newEvent(ctx, output, input))
+			})
+			if v == stop {
+				log.Println("Context:", input.Context, "Stopped by", r.name)
+				return
+			}
+		}
+		for _, r := range rest {
+			run(r.name, r.f, output, input)
+		}
+	}()
+}
+
+// pluginEnabled tells if plugin is enabled in context: it's not listed in
+// "DisabledPlugins", wasn't disabled for panicking, and either listed in
+// "WhitelistedPlugins", or there's no whitelist.
+func pluginEnabled(context map[string]string, plugin string) bool {
+	if _, ok := cfg.LookupStringMap(context, "DisabledPlugins")[plugin]; ok {
+		return false
+	}
+	if panicDisabled(plugin) {
+		return false
+	}
+	if len(cfg.LookupStringMap(context, "WhitelistedPlugins")) == 0 {
+		return true
+	}
+	_, ok := cfg.LookupStringMap(context, "WhitelistedPlugins")[plugin]
+	return ok
 }
 
 // Dispatcher takes irc messages and dispatches them to registered callbacks.
 //
 // It will take an input message, check (based on message context), if the
 // message should be dispatched, and passes it to registered callback.
+//
+// Messages go through filters first, then ordered callbacks, one at a time,
+// and then the rest of callbacks, concurrently. Filters and ordered callbacks
+// can stop the message from going further.
+//
+// Commands are subject to rate limits. Other callbacks aren't, as we can't
+// tell in advance if they're going to act on a message.
 func Dispatcher(output func(irc.Message), input irc.Message) {
-	if _, ok := cfg.LookupStringMap(input.Context, "Ignore")[input.Context["Source"]]; ok {
-		log.Println("Context:", input.Context, "Ignoring", input.Context["Source"])
-		return
-	}
-
 	callbackLock.RLock()
 	defer callbackLock.RUnlock()
-	if callbacks[input.Command] != nil {
-		if len(cfg.LookupStringMap(input.Context, "WhitelistedPlugins")) > 0 {
-			for i, f := range callbacks[input.Command] {
-				if _, ok := cfg.LookupStringMap(input.Context, "DisabledPlugins")[i]; ok {
-					log.Println("Context:", input.Context, "Plugin disabled", i)
-					continue
-				}
-				if _, ok := cfg.LookupStringMap(input.Context, "WhitelistedPlugins")[i]; ok {
-					go f(output, input)
-				} else {
-					log.Println("Context:", input.Context, "Plugin not whitelisted", i)
-				}
+
+	for _, f := range filters {
+		v := pass
+		guard(f.name, input, func(ctx context.Context) {
+			v = f.filter(newEvent(ctx, output, input))
+		})
+		if v == stop {
+			log.Println("Context:", input.Context, "Filtered by", f.name)
+			return
+		}
+	}
+
+	var invoked string
+	if input.Command == "PRIVMSG" {
+		if name, _, ok := parseInvocation(input); ok {
+			if c := lookupCommand(name); c != nil {
+				invoked = c.plugin
 			}
+		}
+	}
+
+	var ordered, rest []*registration
+	for _, r := range callbacks[input.Command] {
+		if !pluginEnabled(input.Context, r.name) {
+			log.Println("Context:", input.Context, "Plugin disabled", r.name)
+			continue
+		}
+		if r.name == invoked && rateLimited(output, input, r.name) {
+			continue
+		}
+		if r.ordered != nil {
+			ordered = append(ordered, r)
 		} else {
-			for i, f := range callbacks[input.Command] {
-				if _, ok := cfg.LookupStringMap(input.Context, "DisabledPlugins")[i]; ok {
-					log.Println("Context:", input.Context, "Plugin disabled", i)
-					continue
-				}
-				go f(output, input)
-			}
+			rest = append(rest, r)
 		}
 	}
+
+	if len(ordered) == 0 {
+		for _, r := range rest {
+			run(r.name, r.f, output, input)
+		}
+		return
+	}
+	runOrdered(ordered, rest, output, input)
 }
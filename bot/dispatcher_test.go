@@ -0,0 +1,64 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	var l sync.Mutex
+	var calls []string
+	record := func(name string, v verdict) orderedCallback {
+		return func(e *Event) verdict {
+			l.Lock()
+			defer l.Unlock()
+			calls = append(calls, name)
+			if e.Message.Trailing == name {
+				return v
+			}
+			return pass
+		}
+	}
+
+	addOrderedCallback("TESTORDER", "b", 10, record("b", stop))
+	addOrderedCallback("TESTORDER", "a", 10, record("a", stop))
+	addOrderedCallback("TESTORDER", "first", -5, record("first", stop))
+	addEventCallback("TESTORDER", "last", func(e *Event) {
+		record("last", pass)(e)
+	})
+	addFilter("testorder", 0, func(e *Event) verdict {
+		if e.Message.Command == "TESTORDER" && e.Message.Trailing == "filtered" {
+			return stop
+		}
+		return pass
+	})
+
+	for _, e := range []struct {
+		trailing string
+		calls    []string
+	}{
+		{"", []string{"first", "a", "b", "last"}},
+		{"a", []string{"first", "a"}},
+		{"first", []string{"first"}},
+		{"filtered", nil},
+	} {
+		calls = nil
+		Dispatcher(func(irc.Message) {}, irc.Message{
+			Command:  "TESTORDER",
+			Trailing: e.trailing,
+			Context:  map[string]string{"Network": "TestNet", "Source": "idontexist"},
+		})
+		running.Wait()
+
+		if !reflect.DeepEqual(calls, e.calls) {
+			t.Errorf("%q: expected calls %v, got %v", e.trailing, e.calls, calls)
+		}
+	}
+}
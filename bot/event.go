@@ -0,0 +1,87 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Event is a message dispatched to a plugin, along with what plugins usually
+// want to know about it.
+type Event struct {
+	// Ctx is cancelled when the plugin runs out of time, or the bot shuts
+	// down.
+	Ctx     context.Context
+	Message irc.Message
+	// Conn is the connection message came from. It's nil if we're not
+	// connected to Network anymore, or in tests.
+	Conn    *irc.Connection
+	Network string
+	// Source is the author of the message. It's nil for messages coming
+	// straight from the server.
+	Source *irc.Prefix
+	// Target is the first parameter of the message: the channel, or our nick
+	// for messages sent to us directly.
+	Target string
+	// Private is true for messages sent to us, and not to a channel.
+	Private bool
+	// Nick is our current nick on Network.
+	Nick string
+
+	output func(irc.Message)
+}
+
+// eventCallback is a callback getting messages as Events.
+type eventCallback func(e *Event)
+
+// newEvent wraps msg in an Event.
+func newEvent(ctx context.Context, output func(irc.Message), msg irc.Message) *Event {
+	e := &Event{
+		Ctx:     ctx,
+		Message: msg,
+		Network: msg.Context["Network"],
+		Source:  msg.Prefix,
+		Nick:    currentNick(msg.Context),
+		output:  output,
+	}
+	e.Conn = irc.GetConnection(e.Network)
+	if len(msg.Params) > 0 {
+		e.Target = msg.Params[0]
+	}
+	e.Private = e.Target != "" && isupport(e.Network).EqualFold(e.Target, e.Nick)
+	return e
+}
+
+// Send sends a message to the network the event came from.
+func (e *Event) Send(msg irc.Message) {
+	e.output(msg)
+}
+
+// Reply sends text to the channel the event came from, or to its author, if
+// it was sent to us directly.
+func (e *Event) Reply(text string) {
+	e.output(reply(e.Message, text))
+}
+
+// Notice sends text to the author of the event, as a NOTICE.
+func (e *Event) Notice(text string) {
+	if e.Source == nil {
+		return
+	}
+	e.output(privateNotice(e.Message, text))
+}
+
+// Action replies with a CTCP ACTION, like "/me text".
+func (e *Event) Action(text string) {
+	e.output(reply(e.Message, "\x01ACTION "+text+"\x01"))
+}
+
+// addEventCallback registers a callback getting messages as Events. Such
+// callbacks run concurrently, in no particular order.
+func addEventCallback(command, name string, f eventCallback) {
+	register(command, &registration{name: name, f: f})
+}
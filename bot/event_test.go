@@ -0,0 +1,77 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func TestEvent(t *testing.T) {
+	var sent []irc.Message
+	output := func(m irc.Message) { sent = append(sent, m) }
+	prefix := &irc.Prefix{Name: "idontexist", User: "test", Host: "example.com"}
+
+	e := newEvent(context.Background(), output, irc.Message{
+		Command:  "PRIVMSG",
+		Prefix:   prefix,
+		Params:   []string{"GorePost"},
+		Trailing: "hi",
+		Context:  map[string]string{"Network": "TestNet", "Source": "idontexist", "Target": "GorePost"},
+	})
+	if !e.Private || e.Nick != "gorepost" || e.Network != "TestNet" || e.Source != prefix || e.Target != "GorePost" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	e.Reply("hello")
+	e.Notice("psst")
+	e.Action("waves")
+	expected := []irc.Message{
+		{Command: "PRIVMSG", Params: []string{"idontexist"}, Trailing: "hello"},
+		{Command: "NOTICE", Params: []string{"idontexist"}, Trailing: "psst"},
+		{Command: "PRIVMSG", Params: []string{"idontexist"}, Trailing: "\x01ACTION waves\x01"},
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("expected %+v, got %+v", expected, sent)
+	}
+
+	e = newEvent(context.Background(), output, irc.Message{
+		Command: "PRIVMSG",
+		Prefix:  prefix,
+		Params:  []string{"#testchan-1"},
+		Context: map[string]string{"Network": "TestNet", "Source": "idontexist", "Target": "#testchan-1"},
+	})
+	if e.Private {
+		t.Error("channel message taken for a private one")
+	}
+}
+
+func TestCallbackAdapter(t *testing.T) {
+	msg := irc.Message{
+		Command:  "TESTADAPTER",
+		Params:   []string{"#testchan-1"},
+		Trailing: "hi",
+		Context:  map[string]string{"Network": "TestNet"},
+	}
+
+	var got irc.Message
+	addCallback("TESTADAPTER", "adapter", func(output func(irc.Message), msg irc.Message) {
+		got = msg
+		output(reply(msg, "hello"))
+	})
+
+	var sent []irc.Message
+	Dispatcher(func(m irc.Message) { sent = append(sent, m) }, msg)
+	running.Wait()
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("expected %+v, got %+v", msg, got)
+	}
+	if len(sent) != 1 || sent[0].Trailing != "hello" {
+		t.Errorf("unexpected output: %+v", sent)
+	}
+}
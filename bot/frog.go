@@ -5,9 +5,9 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
 
 	"github.com/arachnist/gorepost/irc"
@@ -23,11 +23,11 @@ type tips struct {
 	lock sync.RWMutex
 }
 
-func (tips *tips) fetchTips() error {
+func (tips *tips) fetchTips(ctx context.Context) error {
 	tips.lock.Lock()
 	defer tips.lock.Unlock()
 
-	data, err := httpGet("http://frog.tips/api/1/tips/")
+	data, err := httpGet(ctx, "http://frog.tips/api/1/tips/")
 	if err != nil {
 		return err
 	}
@@ -40,9 +40,9 @@ func (tips *tips) fetchTips() error {
 	return nil
 }
 
-func (tips *tips) popTip() string {
+func (tips *tips) popTip(ctx context.Context) string {
 	if len(tips.Tips) == 0 {
-		if err := tips.fetchTips(); err != nil {
+		if err := tips.fetchTips(ctx); err != nil {
 			return fmt.Sprint(err)
 		}
 	}
@@ -50,6 +50,9 @@ func (tips *tips) popTip() string {
 	tips.lock.RLock()
 	defer tips.lock.RUnlock()
 
+	if len(tips.Tips) == 0 {
+		return "no tips"
+	}
 	rmsg := tips.Tips[len(tips.Tips)-1].Tip
 	tips.Tips = tips.Tips[:len(tips.Tips)-1]
 
@@ -58,14 +61,15 @@ func (tips *tips) popTip() string {
 
 var t tips
 
-func frog(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":frog" {
-		return
-	}
-
-	output(reply(msg, t.popTip()))
+func frog(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	output(reply(msg, t.popTip(ctx)))
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "frog", frog)
+	addCommand(&command{
+		name:        "frog",
+		description: "Gives a frog tip",
+		run:         frog,
+	})
 }
@@ -5,22 +5,19 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
-	"strings"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-func google(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":g" {
-		return
-	}
-
-	query := strings.TrimPrefix(msg.Trailing, ":g ")
+func google(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	query := args[0]
 
 	req, _ := http.NewRequest("GET", "https://ajax.googleapis.com/ajax/services/search/web?v=1.0", nil)
+	req = req.WithContext(ctx)
 
 	q := req.URL.Query()
 	q.Set("q", query)
@@ -31,7 +28,7 @@ func google(output func(irc.Message), msg irc.Message) {
 	resp, err := client.Do(req)
 	if err != nil {
 		output(reply(msg, "problem connecting to google"))
-		return
+		return nil
 	}
 
 	defer resp.Body.Close()
@@ -46,15 +43,22 @@ func google(output func(irc.Message), msg irc.Message) {
 	}
 	if errJ := json.NewDecoder(resp.Body).Decode(&data); errJ != nil {
 		output(reply(msg, "problem decoding google response"))
-		return
+		return nil
 	}
 	if len(data.ResponseData.Results) > 0 {
 		res := data.ResponseData.Results[0]
 		link, _ := url.QueryUnescape(res.URL)
 		output(reply(msg, res.TitleNoFormatting+" "+link))
 	}
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "google", google)
+	addCommand(&command{
+		name:        "g",
+		plugin:      "google",
+		args:        "<query...>",
+		description: "Searches google, and links the first result",
+		run:         google,
+	})
 }
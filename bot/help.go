@@ -0,0 +1,77 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Help longer than this is sent in a private NOTICE, instead of to the
+// channel it was asked for in.
+const maxPublicHelp = 100
+
+// enabledCommands returns sorted names of commands enabled in context.
+func enabledCommands(context map[string]string) []string {
+	var r []string
+	for _, name := range commandNames() {
+		if c := lookupCommand(name); c != nil && pluginEnabled(context, c.plugin) {
+			r = append(r, name)
+		}
+	}
+	return r
+}
+
+// helpText describes a single command.
+func (c *command) helpText(context map[string]string) string {
+	r := commandPrefix(context) + c.name
+	if c.description != "" {
+		r += ": " + c.description
+	}
+	r += ". " + c.usageText(context)
+	if len(c.aliases) > 0 {
+		r += ". Aliases: " + strings.Join(c.aliases, ", ")
+	}
+	if c.role != "" {
+		r += ". Requires role: " + c.role
+	}
+	return r
+}
+
+func help(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	var r string
+	prefix := commandPrefix(msg.Context)
+
+	if len(args) == 0 {
+		r = "Commands: " + strings.Join(enabledCommands(msg.Context), ", ") +
+			". Use " + prefix + "help <command> for details"
+	} else {
+		c := lookupCommand(strings.TrimPrefix(args[0], prefix))
+		if c == nil || !pluginEnabled(msg.Context, c.plugin) {
+			r = "No such command: " + args[0]
+		} else {
+			r = c.helpText(msg.Context)
+		}
+	}
+
+	if len(r) > maxPublicHelp {
+		output(privateNotice(msg, r))
+	} else {
+		output(reply(msg, r))
+	}
+	return nil
+}
+
+func init() {
+	addCommand(&command{
+		name:        "help",
+		aliases:     []string{"commands"},
+		args:        "[command]",
+		description: "Lists commands, or describes one of them",
+		run:         help,
+	})
+}
@@ -6,6 +6,7 @@ package bot
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -21,7 +22,8 @@ import (
 
 var errElementNotFound = errors.New("element not found in document")
 
-func httpGet(link string) ([]byte, error) {
+// httpGet fetches link, giving up if ctx is cancelled.
+func httpGet(ctx context.Context, link string) ([]byte, error) {
 	var buf []byte
 	cj, err := cookiejar.New(nil)
 	tr := &http.Transport{
@@ -38,6 +40,7 @@ func httpGet(link string) ([]byte, error) {
 		return []byte{}, err
 	}
 
+	req = req.WithContext(ctx)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.3; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/42.0.2311.152 Safari/537.36")
 
 	resp, err := client.Do(req)
@@ -65,8 +68,8 @@ func httpGet(link string) ([]byte, error) {
 	return buf, nil
 }
 
-func httpGetXpath(link, xpathStr string) (string, error) {
-	buf, err := httpGet(link)
+func httpGetXpath(ctx context.Context, link, xpathStr string) (string, error) {
+	buf, err := httpGet(ctx, link)
 	if err != nil {
 		return "", err
 	}
@@ -94,8 +97,28 @@ func httpGetXpath(link, xpathStr string) (string, error) {
 	return "", errElementNotFound
 }
 
+// isupport returns features of the server we're connected to on network, or
+// defaults if we're not connected.
+func isupport(network string) irc.ISupport {
+	if conn := irc.GetConnection(network); conn != nil {
+		return conn.ISupport()
+	}
+	return irc.DefaultISupport()
+}
+
+// currentNick returns our nick on the network message came from. It differs
+// from configured "Nick" if that one was taken when we connected.
+func currentNick(context map[string]string) string {
+	if conn := irc.GetConnection(context["Network"]); conn != nil {
+		if nick := conn.Nick(); nick != "" {
+			return nick
+		}
+	}
+	return cfg.LookupString(context, "Nick")
+}
+
 func reply(msg irc.Message, text string) irc.Message {
-	if msg.Params[0] == cfg.LookupString(msg.Context, "Nick") {
+	if len(msg.Params) > 0 && msg.Prefix != nil && isupport(msg.Context["Network"]).EqualFold(msg.Params[0], currentNick(msg.Context)) {
 		return irc.Message{
 			Command:  "PRIVMSG",
 			Params:   []string{msg.Prefix.Name},
@@ -110,6 +133,16 @@ func reply(msg irc.Message, text string) irc.Message {
 	}
 }
 
+// privateNotice returns a NOTICE to the author of msg, for replies that would
+// flood the channel.
+func privateNotice(msg irc.Message, text string) irc.Message {
+	return irc.Message{
+		Command:  "NOTICE",
+		Params:   []string{msg.Prefix.Name},
+		Trailing: text,
+	}
+}
+
 func readLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -5,79 +5,167 @@
 package bot
 
 import (
+	"context"
 	"strings"
+	"sync"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-var identified map[string]map[string]string
+// identification is what WHOIS told us about a user: their user@host, and
+// services account, if they're logged in to one. Account is only trusted for
+// the same user@host, so whoever takes the nick after the user leaves doesn't
+// inherit it.
+type identification struct {
+	user    string
+	host    string
+	account string
+}
+
+// Identified users, by network, and folded nick. Forgotten on reconnect, as we
+// don't see users leaving while we're away.
+var identified map[string]map[string]identification
+var identifiedLock sync.RWMutex
 
 func IsIdentified(msg irc.Message) bool {
 	if msg.Prefix == nil {
 		return false
 	}
-	if identified == nil {
-		return false
-	}
-	net := msg.Context["Network"]
-	if identified[net] == nil {
-		return false
-	}
-	if identified[net][msg.Prefix.Name] == "" {
-		return false
-	}
 
-	return true
+	return identifiedAccount(msg.Context["Network"], msg.Prefix) != ""
 }
 
-func identify(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":identify" {
-		return
+// identifiedAccount returns account user was identified as with WHOIS, or an
+// empty string. User has to have the same user@host they had then.
+func identifiedAccount(network string, prefix *irc.Prefix) string {
+	identifiedLock.RLock()
+	defer identifiedLock.RUnlock()
+
+	if identified == nil || identified[network] == nil {
+		return ""
+	}
+	server := isupport(network)
+	i, ok := identified[network][server.Fold(prefix.Name)]
+	if !ok || i.user != prefix.User || !server.EqualFold(i.host, prefix.Host) {
+		return ""
 	}
+	return i.account
+}
 
+func identify(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	output(irc.Message{
 		Command: "WHOIS",
 		Params:  []string{msg.Prefix.Name},
 	})
+	return nil
 }
 
-func registerIdentification(output func(irc.Message), msg irc.Message) {
+// registerWhoisUser starts a WHOIS reply, recording user@host of the user.
+// RPL_WHOISUSER <client> <nick> <user> <host> * :<realname>
+func registerWhoisUser(output func(irc.Message), msg irc.Message) {
+	if len(msg.Params) < 4 {
+		return
+	}
 	net := msg.Context["Network"]
 
+	identifiedLock.Lock()
+	defer identifiedLock.Unlock()
+
 	if identified == nil {
-		identified = make(map[string]map[string]string)
+		identified = make(map[string]map[string]identification)
 	}
 
 	if _, ok := identified[net]; !ok {
-		identified[net] = make(map[string]string)
+		identified[net] = make(map[string]identification)
+	}
+
+	// Any account from an earlier WHOIS is dropped, until 330 says otherwise.
+	identified[net][isupport(net).Fold(msg.Params[1])] = identification{
+		user: msg.Params[2],
+		host: msg.Params[3],
+	}
+}
+
+// registerIdentification records account of a user whose WHOIS reply started
+// with registerWhoisUser.
+// RPL_WHOISACCOUNT <client> <nick> <account> :is logged in as
+func registerIdentification(output func(irc.Message), msg irc.Message) {
+	if len(msg.Params) < 3 {
+		return
 	}
+	net := msg.Context["Network"]
+	nick := isupport(net).Fold(msg.Params[1])
+
+	identifiedLock.Lock()
+	defer identifiedLock.Unlock()
 
-	identified[net][msg.Params[1]] = msg.Params[2]
+	i, ok := identified[net][nick]
+	if !ok {
+		return
+	}
+	i.account = msg.Params[2]
+	identified[net][nick] = i
 }
 
-func listIdentified(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":idlist" {
+// resetIdentification forgets everyone identified on a network, once we
+// (re)connect to it.
+func resetIdentification(output func(irc.Message), msg irc.Message) {
+	identifiedLock.Lock()
+	defer identifiedLock.Unlock()
+
+	delete(identified, msg.Context["Network"])
+}
+
+// forgetIdentification drops identification of users who change nicks, or
+// quit, so whoever takes the nick next doesn't inherit it.
+func forgetIdentification(output func(irc.Message), msg irc.Message) {
+	if msg.Prefix == nil {
 		return
 	}
+	net := msg.Context["Network"]
 
-	if cfg.LookupInt(msg.Context, "AccessLevel") < 10 {
-		output(reply(msg, "access denied"))
+	identifiedLock.Lock()
+	defer identifiedLock.Unlock()
+
+	if identified == nil || identified[net] == nil {
 		return
 	}
+	delete(identified[net], isupport(net).Fold(msg.Prefix.Name))
+}
 
+func listIdentified(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var r []string
 
+	identifiedLock.RLock()
 	for _, net := range identified {
-		for k, v := range net {
-			r = append(r, k+" identified as "+v)
+		for nick, i := range net {
+			if i.account != "" {
+				r = append(r, nick+"!"+i.user+"@"+i.host+" identified as "+i.account)
+			}
 		}
 	}
+	identifiedLock.RUnlock()
 
 	output(reply(msg, strings.Join(r, "; ")))
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "identify", identify)
-	addCallback("PRIVMSG", "list identified", listIdentified)
+	addCommand(&command{
+		name:        "identify",
+		description: "Checks which services account you're logged in to, for access control",
+		run:         identify,
+	})
+	addCommand(&command{
+		name:        "idlist",
+		plugin:      "list identified",
+		role:        "admin",
+		description: "Lists identified users",
+		run:         listIdentified,
+	})
+	addCallback("001", "register identification", resetIdentification)
+	addCallback("311", "register identification", registerWhoisUser)
 	addCallback("330", "register identification", registerIdentification)
+	addCallback("NICK", "register identification", forgetIdentification)
+	addCallback("QUIT", "register identification", forgetIdentification)
 }
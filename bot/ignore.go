@@ -0,0 +1,297 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// ignoreRule ignores users matching Mask on a network: in Channel only, if
+// it's set, and until Expires, if it's set. Mask is anything aclMatch
+// accepts: a nick!user@host mask, or "$a:account".
+type ignoreRule struct {
+	Mask    string
+	Channel string
+	Expires time.Time
+	By      string // who added the rule
+}
+
+// applies tells if rule is in force for a message sent to target.
+func (r ignoreRule) applies(server irc.ISupport, target string) bool {
+	if expired(r.Expires) {
+		return false
+	}
+	return r.Channel == "" || server.EqualFold(r.Channel, target)
+}
+
+func (r ignoreRule) String() string {
+	s := r.Mask
+	if r.Channel != "" {
+		s += " on " + r.Channel
+	}
+	if !r.Expires.IsZero() {
+		s += " until " + r.Expires.Format("2006-01-02 15:04")
+	}
+	if r.By != "" {
+		s += " (by " + r.By + ")"
+	}
+	return s
+}
+
+// Ignore rules added with :ignore are kept in store, under network name, and
+// cached here, as they're checked for every message.
+var ignoreCache = make(map[string][]ignoreRule)
+var ignoreLock sync.Mutex
+
+var errNoIgnoreRule = errors.New("no such rule")
+
+// ignoreRules returns rules stored for network.
+func ignoreRules(network string) []ignoreRule {
+	ignoreLock.Lock()
+	defer ignoreLock.Unlock()
+
+	if rules, ok := ignoreCache[network]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	b, err := store.Get("ignore", network)
+	if err == nil {
+		err = json.Unmarshal(b, &rules)
+	}
+	if err != nil && err != errNotFound {
+		log.Println("ignore: error loading rules for", network, err)
+	}
+	ignoreCache[network] = rules
+	return rules
+}
+
+// updateIgnoreRules replaces rules stored for network with ones returned by
+// f. Expired rules are dropped before f sees them.
+func updateIgnoreRules(network string, f func([]ignoreRule) ([]ignoreRule, error)) error {
+	ignoreLock.Lock()
+	defer ignoreLock.Unlock()
+
+	var rules []ignoreRule
+	err := store.Update("ignore", network, 0, func(b []byte) ([]byte, error) {
+		var old []ignoreRule
+		if b != nil {
+			if err := json.Unmarshal(b, &old); err != nil {
+				return nil, err
+			}
+		}
+
+		var current []ignoreRule
+		for _, r := range old {
+			if !expired(r.Expires) {
+				current = append(current, r)
+			}
+		}
+
+		var err error
+		rules, err = f(current)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rules)
+	})
+	if err != nil {
+		return err
+	}
+
+	ignoreCache[network] = rules
+	return nil
+}
+
+// ignoreMatch checks if author of msg matches entry of static "Ignore" list,
+// which can be a nick, or anything aclMatch accepts.
+func ignoreMatch(msg irc.Message, entry string) bool {
+	if strings.ContainsAny(entry, "!@$*?") {
+		return aclMatch(msg, entry)
+	}
+	return isupport(msg.Context["Network"]).EqualFold(entry, msg.Prefix.Name)
+}
+
+// ignoreFilter drops messages from users listed in "Ignore", or matching
+// rules added with :ignore. Admins are never ignored, so they can't lock
+// themselves out.
+func ignoreFilter(e *Event) verdict {
+	if e.Source == nil {
+		return pass
+	}
+
+	ignored := false
+	for _, entry := range cfg.LookupStringSlice(e.Message.Context, "Ignore") {
+		if ignoreMatch(e.Message, entry) {
+			ignored = true
+			break
+		}
+	}
+	if !ignored {
+		server := isupport(e.Network)
+		for _, r := range ignoreRules(e.Network) {
+			if r.applies(server, e.Target) && aclMatch(e.Message, r.Mask) {
+				ignored = true
+				break
+			}
+		}
+	}
+
+	if ignored && !hasRole(e.Message, adminRole) {
+		return stop
+	}
+	return pass
+}
+
+// Subcommands of :ignore, with arguments parsed like those of commands.
+var ignoreSubcommands = map[string]*command{
+	"add":  {name: "add", args: "[@network] <mask> [channel] [duration]", run: ignoreAdd},
+	"del":  {name: "del", args: "[@network] <mask> [channel]", run: ignoreDel},
+	"list": {name: "list", args: "[@network]", run: ignoreList},
+}
+
+// ignoreMask turns a bare nick into a mask.
+func ignoreMask(mask string) string {
+	if strings.ContainsAny(mask, "!@$*?") {
+		return mask
+	}
+	return mask + "!*@*"
+}
+
+func ignoreNetwork(msg irc.Message, network string) string {
+	if network == "" {
+		return msg.Context["Network"]
+	}
+	return network
+}
+
+func ignoreAdd(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	network := ignoreNetwork(msg, args[0])
+	rule := ignoreRule{Mask: ignoreMask(args[1]), By: msg.Prefix.Name}
+
+	// Channel can be left out, with duration given in its place.
+	rest := args[2:]
+	if len(rest) > 0 && isupport(network).IsChannel(rest[0]) {
+		rule.Channel = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 1 {
+		return errUsage
+	}
+	if len(rest) == 1 {
+		d, err := time.ParseDuration(rest[0])
+		if err != nil || d <= 0 {
+			return errUsage
+		}
+		rule.Expires = time.Now().Add(d).Round(time.Second)
+	}
+
+	server := isupport(network)
+	err := updateIgnoreRules(network, func(rules []ignoreRule) ([]ignoreRule, error) {
+		for i, r := range rules {
+			if server.EqualFold(r.Mask, rule.Mask) && server.EqualFold(r.Channel, rule.Channel) {
+				rules[i] = rule
+				return rules, nil
+			}
+		}
+		return append(rules, rule), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	adminLog(msg, "ignoring", rule.String(), "on", network)
+	output(reply(msg, "ignoring "+rule.String()))
+	return nil
+}
+
+func ignoreDel(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	network := ignoreNetwork(msg, args[0])
+	mask := ignoreMask(args[1])
+	var channel string
+	if len(args) > 2 {
+		channel = args[2]
+	}
+
+	server := isupport(network)
+	err := updateIgnoreRules(network, func(rules []ignoreRule) ([]ignoreRule, error) {
+		for i, r := range rules {
+			if server.EqualFold(r.Mask, mask) && server.EqualFold(r.Channel, channel) {
+				return append(rules[:i:i], rules[i+1:]...), nil
+			}
+		}
+		return nil, errNoIgnoreRule
+	})
+	if err != nil {
+		return err
+	}
+
+	adminLog(msg, "no longer ignoring", mask, channel, "on", network)
+	output(reply(msg, "no longer ignoring "+mask))
+	return nil
+}
+
+func ignoreList(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	network := ignoreNetwork(msg, args[0])
+
+	var r []string
+	for _, entry := range cfg.LookupStringSlice(map[string]string{"Network": network}, "Ignore") {
+		r = append(r, entry+" (static)")
+	}
+	for _, rule := range ignoreRules(network) {
+		if !expired(rule.Expires) {
+			r = append(r, rule.String())
+		}
+	}
+
+	if len(r) == 0 {
+		output(privateNotice(msg, "not ignoring anyone on "+network))
+		return nil
+	}
+	output(privateNotice(msg, strings.Join(r, "; ")))
+	return nil
+}
+
+func ignore(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	sub, ok := ignoreSubcommands[strings.ToLower(args[0])]
+	if !ok {
+		return errUsage
+	}
+
+	var line string
+	if len(args) > 1 {
+		line = args[1]
+	}
+	subArgs, err := sub.parseArgs(line)
+	if err != nil {
+		return err
+	}
+	return sub.run(ctx, output, msg, subArgs)
+}
+
+func init() {
+	for _, sub := range ignoreSubcommands {
+		sub.spec = parseArgSpec(sub.args)
+	}
+
+	addFilter("ignore", 0, ignoreFilter)
+	addCommand(&command{
+		name:        "ignore",
+		plugin:      "admin",
+		args:        "<action> [arguments...]",
+		usage:       "add [@network] <mask> [channel] [duration] | del [@network] <mask> [channel] | list [@network]",
+		description: "Manages ignored users",
+		role:        adminRole,
+		run:         ignore,
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func ignoreTestMessage(prefix, target string) irc.Message {
+	p := irc.ParsePrefix(prefix)
+	return irc.Message{
+		Command:  "PRIVMSG",
+		Prefix:   p,
+		Params:   []string{target},
+		Trailing: "hi",
+		Context:  map[string]string{"Network": "TestNet", "Source": p.Name, "Target": target},
+	}
+}
+
+func ignored(msg irc.Message) bool {
+	return ignoreFilter(newEvent(context.Background(), func(irc.Message) {}, msg)) == stop
+}
+
+func runIgnore(line string) string {
+	msg := ignoreTestMessage("someone!u@admin.example.com", "#testchan-1")
+	msg.Trailing = ":ignore " + line
+
+	var r []string
+	commandCallback("ignore")(context.Background(), func(m irc.Message) {
+		r = append(r, m.Trailing)
+	}, msg)
+	return strings.Join(r, "\n")
+}
+
+func TestIgnore(t *testing.T) {
+	defer store.Delete("ignore", "TestNet")
+	defer delete(ignoreCache, "TestNet")
+
+	for _, e := range []struct {
+		prefix, target string
+		ignored        bool
+	}{
+		{"IgnoreMe!u@h", "#testchan-1", true},
+		{"someone!u@spam.example.com", "#testchan-1", true},
+		{"someone!u@h", "#testchan-1", false},
+		{"ignoreme!u@admin.example.com", "#testchan-1", false},
+	} {
+		if ignored(ignoreTestMessage(e.prefix, e.target)) != e.ignored {
+			t.Errorf("%s: expected ignored %v", e.prefix, e.ignored)
+		}
+	}
+
+	for _, e := range []struct {
+		line, out string
+	}{
+		{"add troll", "ignoring troll!*@* (by someone)"},
+		{"add *!*@bad.example.com #testchan-2", "ignoring *!*@bad.example.com on #testchan-2 (by someone)"},
+		{"add $a:spammer 1h", "ignoring $a:spammer until "},
+		{"add troll #testchan-1 1h 2h", "Usage: :ignore add [@network] <mask> [channel] [duration] | del [@network] <mask> [channel] | list [@network]"},
+		{"frob", "Usage: :ignore add [@network] <mask> [channel] [duration] | del [@network] <mask> [channel] | list [@network]"},
+	} {
+		if out := runIgnore(e.line); !strings.HasPrefix(out, e.out) {
+			t.Errorf("%s: expected %q, got %q", e.line, e.out, out)
+		}
+	}
+
+	spammer := ignoreTestMessage("someone!u@h", "#testchan-1")
+	spammer.Tags = map[string]string{"account": "spammer"}
+	for _, e := range []struct {
+		msg     irc.Message
+		ignored bool
+	}{
+		{ignoreTestMessage("Troll!u@h", "#testchan-1"), true},
+		{ignoreTestMessage("someone!u@bad.example.com", "#testchan-1"), false},
+		{ignoreTestMessage("someone!u@bad.example.com", "#TestChan-2"), true},
+		{spammer, true},
+	} {
+		if ignored(e.msg) != e.ignored {
+			t.Errorf("%s to %s: expected ignored %v", e.msg.Prefix, e.msg.Params[0], e.ignored)
+		}
+	}
+
+	// Rules survive losing the cache.
+	delete(ignoreCache, "TestNet")
+	if !ignored(ignoreTestMessage("troll!u@h", "#testchan-1")) {
+		t.Error("rule not loaded from store")
+	}
+
+	if out := runIgnore("del troll"); out != "no longer ignoring troll!*@*" {
+		t.Errorf("unexpected del output: %q", out)
+	}
+	if out := runIgnore("del troll"); out != "error:no such rule" {
+		t.Errorf("unexpected del output: %q", out)
+	}
+	if ignored(ignoreTestMessage("troll!u@h", "#testchan-1")) {
+		t.Error("troll still ignored")
+	}
+
+	err := updateIgnoreRules("TestNet", func(rules []ignoreRule) ([]ignoreRule, error) {
+		return append(rules, ignoreRule{Mask: "old!*@*", Expires: time.Now().Add(-time.Minute)}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ignored(ignoreTestMessage("old!u@h", "#testchan-1")) {
+		t.Error("expired rule still applies")
+	}
+
+	out := runIgnore("list")
+	for _, s := range []string{"ignoreme (static)", "*!*@bad.example.com on #testchan-2", "$a:spammer until"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in list, got %q", s, out)
+		}
+	}
+	if strings.Contains(out, "old!") || strings.Contains(out, "troll") {
+		t.Errorf("unexpected rules in list: %q", out)
+	}
+}
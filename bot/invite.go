@@ -8,13 +8,13 @@ import (
 	"github.com/arachnist/gorepost/irc"
 )
 
-func invite(output func(irc.Message), msg irc.Message) {
-	output(irc.Message{
+func invite(e *Event) {
+	e.Send(irc.Message{
 		Command: "JOIN",
-		Params:  []string{msg.Trailing},
+		Params:  []string{e.Message.Trailing},
 	})
 }
 
 func init() {
-	addCallback("INVITE", "invitki", invite)
+	addEventCallback("INVITE", "invitki", invite)
 }
@@ -5,32 +5,30 @@
 package bot
 
 import (
-	"log"
+	"context"
+	"errors"
 	"math/rand"
 	"strings"
 	"time"
 
+	"github.com/arachnist/dyncfg"
+
 	"github.com/arachnist/gorepost/irc"
 )
 
 var objects []string
 var predicates []string
 
-func jan(output func(irc.Message), msg irc.Message) {
-	args := strings.Split(msg.Trailing, " ")
-	if args[0] != ":jan" {
-		return
-	}
-
+func jan(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var predicate string
 	var object string
 
-	if len(args) > 1 {
-		if strings.HasSuffix(args[1], "ł") {
-			predicate = args[1]
+	if len(args) > 0 {
+		if strings.HasSuffix(args[0], "ł") {
+			predicate = args[0]
 			object = objects[rand.Intn(len(objects))]
 		} else {
-			object = args[1]
+			object = args[0]
 			predicate = predicates[rand.Intn(len(predicates))]
 		}
 	} else {
@@ -41,25 +39,42 @@ func jan(output func(irc.Message), msg irc.Message) {
 	str := "Jan Paweł II " + predicate + " małe " + object
 
 	output(reply(msg, str))
+	return nil
 }
 
-func lazyJanInit() {
+// janPlugin loads objects and predicates from "DictionaryObjects" and
+// "DictionaryVerbs" files.
+type janPlugin struct{}
+
+func (janPlugin) Name() string { return "jan" }
+
+func (janPlugin) Init(ctx context.Context, cfg *dyncfg.Dyncfg) error {
 	var err error
 	rand.Seed(time.Now().UnixNano())
 	objects, err = readLines(cfg.LookupString(nil, "DictionaryObjects"))
 	if err != nil {
-		log.Println("failed to read objects", err)
-		return
+		return err
 	}
 	predicates, err = readLines(cfg.LookupString(nil, "DictionaryVerbs"))
 	if err != nil {
-		log.Println("failed to read predicates", err)
-		return
+		return err
+	}
+	if len(objects) == 0 || len(predicates) == 0 {
+		return errors.New("no objects or predicates in dictionaries")
 	}
-	addCallback("PRIVMSG", "jan", jan)
+	addCommand(&command{
+		name:        "jan",
+		args:        "[word]",
+		description: "Tells what Jan Paweł II did",
+		run:         jan,
+	})
+	return nil
 }
 
+func (janPlugin) Close() error { return nil }
+
+func (janPlugin) Health() error { return nil }
+
 func init() {
-	log.Println("Defering \"jan\" initialization")
-	addInit(lazyJanInit)
+	addPlugin(janPlugin{})
 }
@@ -5,6 +5,7 @@
 package bot
 
 import (
+	"context"
 	"math/rand"
 	"strings"
 	"time"
@@ -62,20 +63,20 @@ var set3 = []string{
 	"bo już nie mordują, tylko kradną",
 }
 
-func korwin(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":korwin" {
-		return
-	}
-
+func korwin(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	output(reply(msg, strings.Join([]string{
 		set1[rand.Intn(len(set1))],
 		set2[rand.Intn(len(set2))],
 		set3[rand.Intn(len(set3))],
 	}, " ")))
-
+	return nil
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-	addCallback("PRIVMSG", "korwin", korwin)
+	addCommand(&command{
+		name:        "korwin",
+		description: "Generates a Korwin quote",
+		run:         korwin,
+	})
 }
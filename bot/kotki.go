@@ -5,9 +5,9 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/arachnist/gorepost/irc"
@@ -19,11 +19,7 @@ func redirectError(*http.Request, []*http.Request) error {
 	return errNotReally
 }
 
-func kotki(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":kotki" {
-		return
-	}
-
+func kotki(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var rmsg string
 	tr := &http.Transport{
 		TLSHandshakeTimeout:   5 * time.Second,
@@ -34,13 +30,33 @@ func kotki(output func(irc.Message), msg irc.Message) {
 		CheckRedirect: redirectError,
 	}
 
-	resp, _ := client.Get("http://thecatapi.com/api/images/get?format=src&type=png")
-	rurl, _ := resp.Location()
+	req, err := http.NewRequest("GET", "http://thecatapi.com/api/images/get?format=src&type=png", nil)
+	if err != nil {
+		return err
+	}
+
+	// Stopping at the redirect gets us the response along with
+	// errNotReally; if there's no response, the request failed.
+	resp, err := client.Do(req.WithContext(ctx))
+	if resp == nil {
+		return err
+	}
+	resp.Body.Close()
+
+	rurl, err := resp.Location()
+	if err != nil {
+		return err
+	}
 	rmsg = rurl.String()
 
 	output(reply(msg, rmsg))
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "kotki", kotki)
+	addCommand(&command{
+		name:        "kotki",
+		description: "Links a random cat picture",
+		run:         kotki,
+	})
 }
@@ -5,19 +5,20 @@
 package bot
 
 import (
-	"strings"
-
+	"context"
 	"github.com/arachnist/gorepost/irc"
 )
 
-func ping(output func(irc.Message), msg irc.Message) {
-	if strings.Split(msg.Trailing, " ")[0] != ":ping" {
-		return
-	}
-
+func ping(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	output(reply(msg, "pingity pong"))
+	return nil
 }
 
 func init() {
-	addCallback("PRIVMSG", "msgping", ping)
+	addCommand(&command{
+		name:        "ping",
+		plugin:      "msgping",
+		description: "Checks if the bot is alive",
+		run:         ping,
+	})
 }
@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sync"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
+// Networks we've identified to NickServ on, since we last connected.
+var nickservIdentified = make(map[string]bool)
+var nickservLock sync.Mutex
+
 func nickserv(output func(irc.Message), msg irc.Message) {
 	if msg.Prefix.String() != cfg.LookupString(msg.Context, "NickServPrefix") {
 		log.Println("Context:", msg.Context, "Someone is spoofing nickserv!")
@@ -29,6 +34,11 @@ func nickserv(output func(irc.Message), msg irc.Message) {
 		return
 	}
 
+	if saslLoggedIn(msg.Context["Network"]) {
+		log.Println("Context:", msg.Context, "Already logged in using SASL")
+		return
+	}
+
 	log.Println("Context:", msg.Context, "Identifying to nickserv!")
 	output(reply(msg, fmt.Sprintf("IDENTIFY %s", cfg.LookupString(msg.Context, "NickServPassword"))))
 }
@@ -50,13 +60,21 @@ func joinsecuredchannels(output func(irc.Message), msg irc.Message) {
 		return
 	}
 
-	channels := cfg.LookupStringSlice(msg.Context, "SecuredChannels")
+	nickservLock.Lock()
+	nickservIdentified[msg.Context["Network"]] = true
+	nickservLock.Unlock()
+
+	joinSecuredChannels(output, msg.Context)
+}
+
+func joinSecuredChannels(output func(irc.Message), context map[string]string) {
+	channels := cfg.LookupStringSlice(context, "SecuredChannels")
 	if len(channels) < 1 || channels[0] == "" {
 		return
 	}
 
 	for _, channel := range channels {
-		log.Println(msg.Context["Network"], "joining channel", channel)
+		log.Println(context["Network"], "joining channel", channel)
 		output(irc.Message{
 			Command: "JOIN",
 			Params:  []string{channel},
@@ -64,7 +82,35 @@ func joinsecuredchannels(output func(irc.Message), msg irc.Message) {
 	}
 }
 
+// saslLoggedIn checks if the server told us we're logged in on given network,
+// usually after SASL during connection registration.
+func saslLoggedIn(network string) bool {
+	conn := irc.GetConnection(network)
+	return conn != nil && conn.Account() != ""
+}
+
+// forgetNickserv forgets we've identified to NickServ on a network, once we
+// (re)connect to it.
+func forgetNickserv(output func(irc.Message), msg irc.Message) {
+	nickservLock.Lock()
+	defer nickservLock.Unlock()
+	delete(nickservIdentified, msg.Context["Network"])
+}
+
+// Identified checks if we're logged in to services on network, either with
+// SASL, or as NickServ told us.
+func Identified(network string) bool {
+	if saslLoggedIn(network) {
+		return true
+	}
+
+	nickservLock.Lock()
+	defer nickservLock.Unlock()
+	return nickservIdentified[network]
+}
+
 func init() {
 	addCallback("NOTICE", "nickserv", nickserv)
 	addCallback("NOTICE", "join +i-only channels", joinsecuredchannels)
+	addCallback("001", "join +i-only channels", forgetNickserv)
 }
@@ -0,0 +1,36 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func TestNickservIdentified(t *testing.T) {
+	context := map[string]string{"Network": "TestIdentifiedNet"}
+	output := func(irc.Message) {}
+
+	if Identified("TestIdentifiedNet") {
+		t.Fatal("identified before talking to NickServ")
+	}
+
+	joinsecuredchannels(output, irc.Message{
+		Command:  "NOTICE",
+		Params:   []string{"gorepost"},
+		Trailing: "You are now identified for gorepost.",
+		Prefix:   &irc.Prefix{Name: "NickServ", User: "NickServ", Host: "services."},
+		Context:  context,
+	})
+	if !Identified("TestIdentifiedNet") {
+		t.Error("not identified after NickServ confirmed it")
+	}
+
+	forgetNickserv(output, irc.Message{Command: "001", Params: []string{"gorepost"}, Context: context})
+	if Identified("TestIdentifiedNet") {
+		t.Error("still identified after reconnecting")
+	}
+}
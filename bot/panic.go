@@ -0,0 +1,89 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Number of panics after which a plugin is disabled, if "PanicLimit" isn't
+// configured.
+const defaultPanicLimit = 3
+
+type panicCount struct {
+	count    int
+	disabled bool
+}
+
+var panics = make(map[string]*panicCount)
+var panicLock sync.Mutex
+
+// panicLimit returns "PanicLimit" for context. Negative limit means plugins
+// are never disabled.
+func panicLimit(context map[string]string) int {
+	if limit := cfg.LookupInt(context, "PanicLimit"); limit != 0 {
+		return limit
+	}
+	return defaultPanicLimit
+}
+
+// recordPanic counts a panic of plugin, and disables it everywhere once it
+// reaches the limit, until it's enabled again with :enable.
+func recordPanic(context map[string]string, plugin string) {
+	panicLock.Lock()
+	defer panicLock.Unlock()
+
+	p, ok := panics[plugin]
+	if !ok {
+		p = &panicCount{}
+		panics[plugin] = p
+	}
+	p.count++
+
+	if limit := panicLimit(context); limit > 0 && p.count >= limit && !p.disabled {
+		log.Println("Context:", context, "Plugin", plugin, "panicked", p.count, "times, disabling")
+		p.disabled = true
+	}
+}
+
+// panicDisabled tells if plugin was disabled for panicking too much.
+func panicDisabled(plugin string) bool {
+	panicLock.Lock()
+	defer panicLock.Unlock()
+
+	p, ok := panics[plugin]
+	return ok && p.disabled
+}
+
+// resetPanics forgets panics of plugin, enabling it again. Returns false if
+// it didn't panic at all.
+func resetPanics(plugin string) bool {
+	panicLock.Lock()
+	defer panicLock.Unlock()
+
+	_, ok := panics[plugin]
+	delete(panics, plugin)
+	return ok
+}
+
+// panicReport describes plugins that panicked.
+func panicReport() []string {
+	panicLock.Lock()
+	defer panicLock.Unlock()
+
+	var r []string
+	for plugin, p := range panics {
+		state := "panicked"
+		if p.disabled {
+			state = "disabled after panicking"
+		}
+		r = append(r, fmt.Sprintf("%s: %s %d times", plugin, state, p.count))
+	}
+	sort.Strings(r)
+	return r
+}
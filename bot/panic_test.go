@@ -0,0 +1,79 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	defer resetPanics("panicky")
+	msg := irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"#testchan-1"},
+		Context: map[string]string{"Network": "TestNet", "Source": "idontexist", "Target": "#testchan-1"},
+	}
+	panicky := func(e *Event) {
+		var m map[string]string
+		m["boom"] = e.Message.Trailing
+	}
+
+	for i := 1; i <= defaultPanicLimit; i++ {
+		if !pluginEnabled(msg.Context, "panicky") {
+			t.Fatalf("plugin disabled after %d panics", i-1)
+		}
+		run("panicky", panicky, func(irc.Message) {}, msg)
+		running.Wait()
+	}
+
+	if pluginEnabled(msg.Context, "panicky") {
+		t.Errorf("plugin still enabled after %d panics", defaultPanicLimit)
+	}
+	if r := panicReport(); len(r) != 1 || r[0] != "panicky: disabled after panicking 3 times" {
+		t.Errorf("unexpected report: %q", r)
+	}
+
+	if !resetPanics("panicky") || !pluginEnabled(msg.Context, "panicky") {
+		t.Error("plugin not enabled again")
+	}
+	if resetPanics("panicky") {
+		t.Error("reset a plugin that didn't panic")
+	}
+}
+
+func TestCallbackContext(t *testing.T) {
+	msg := irc.Message{
+		Command: "PING",
+		Context: map[string]string{"Network": "TestNet"},
+	}
+
+	var hasDeadline bool
+	run("deadline", func(e *Event) {
+		_, hasDeadline = e.Ctx.Deadline()
+	}, func(irc.Message) {}, msg)
+	running.Wait()
+
+	if !hasDeadline {
+		t.Error("callback context has no deadline")
+	}
+}
+
+func TestReplyWithoutPrefix(t *testing.T) {
+	msg := irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"gorepost"},
+		Context: map[string]string{"Network": "TestNet"},
+	}
+
+	if r := reply(msg, "text"); len(r.Params) != 1 || r.Params[0] != "gorepost" {
+		t.Errorf("unexpected reply: %+v", r)
+	}
+	msg.Params = nil
+	if r := reply(msg, "text"); len(r.Params) != 0 {
+		t.Errorf("unexpected reply: %+v", r)
+	}
+}
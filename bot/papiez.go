@@ -5,39 +5,53 @@
 package bot
 
 import (
-	"log"
+	"context"
+	"errors"
 	"math/rand"
-	"strings"
 	"time"
 
+	"github.com/arachnist/dyncfg"
+
 	"github.com/arachnist/gorepost/irc"
 )
 
 var adjectives []string
 
-func papiez(output func(irc.Message), msg irc.Message) {
-	args := strings.Split(msg.Trailing, " ")
-	if args[0] != ":papiez" && args[0] != ":papież" {
-		return
-	}
-
+func papiez(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	choice := "Papież " + adjectives[rand.Intn(len(adjectives))]
 
 	output(reply(msg, choice))
+	return nil
 }
 
-func lazyPapiezInit() {
+// papiezPlugin loads adjectives from "DictionaryAdjectives" file.
+type papiezPlugin struct{}
+
+func (papiezPlugin) Name() string { return "papiez" }
+
+func (papiezPlugin) Init(ctx context.Context, cfg *dyncfg.Dyncfg) error {
 	var err error
 	rand.Seed(time.Now().UnixNano())
 	adjectives, err = readLines(cfg.LookupString(nil, "DictionaryAdjectives"))
 	if err != nil {
-		log.Println("failed to read adjectives", err)
-		return
+		return err
+	}
+	if len(adjectives) == 0 {
+		return errors.New("no adjectives in dictionary")
 	}
-	addCallback("PRIVMSG", "papiez", papiez)
+	addCommand(&command{
+		name:        "papiez",
+		aliases:     []string{"papież"},
+		description: "Describes the pope",
+		run:         papiez,
+	})
+	return nil
 }
 
+func (papiezPlugin) Close() error { return nil }
+
+func (papiezPlugin) Health() error { return nil }
+
 func init() {
-	log.Println("Defering \"papiez\" initialization")
-	addInit(lazyPapiezInit)
+	addPlugin(papiezPlugin{})
 }
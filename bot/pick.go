@@ -5,6 +5,7 @@
 package bot
 
 import (
+	"context"
 	"math/rand"
 	"strings"
 	"time"
@@ -12,28 +13,33 @@ import (
 	"github.com/arachnist/gorepost/irc"
 )
 
-func pick(output func(irc.Message), msg irc.Message) {
-	var args []string
-	if !strings.HasPrefix(msg.Trailing, ":pick ") {
-		return
-	}
-
-	a := strings.TrimPrefix(msg.Trailing, ":pick ")
+func pick(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
+	var choices []string
+	a := args[0]
 
 	if strings.Contains(a, ", ") {
-		args = strings.Split(a, ", ")
+		choices = strings.Split(a, ", ")
 	} else if strings.Contains(a, ",") {
-		args = strings.Split(a, ",")
+		choices = strings.Split(a, ",")
 	} else {
-		args = strings.Fields(a)
+		choices = strings.Fields(a)
 	}
 
-	choice := args[rand.Intn(len(args))]
+	if len(choices) == 0 {
+		return errUsage
+	}
+	choice := choices[rand.Intn(len(choices))]
 
 	output(reply(msg, choice))
+	return nil
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-	addCallback("PRIVMSG", "pick", pick)
+	addCommand(&command{
+		name:        "pick",
+		args:        "<choices...>",
+		description: "Picks one of choices separated with commas, or spaces",
+		run:         pick,
+	})
 }
@@ -0,0 +1,145 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/arachnist/dyncfg"
+)
+
+// Plugin is implemented by plugins that have to set things up before they can
+// work, or clean up after themselves. Simple plugins can just register
+// callbacks with addEventCallback, or commands with addCommand, in init().
+type Plugin interface {
+	Name() string
+	// Init sets the plugin up, and registers its callbacks. If it fails, the
+	// plugin is left out.
+	Init(ctx context.Context, cfg *dyncfg.Dyncfg) error
+	// Close is called on shutdown, once callbacks are done.
+	Close() error
+	// Health returns nil if the plugin works, or what's wrong with it.
+	Health() error
+}
+
+// Plugin states.
+const (
+	pluginRegistered = "registered"
+	pluginLoaded     = "loaded"
+	pluginFailed     = "failed"
+	pluginDisabled   = "disabled"
+)
+
+type pluginStatus struct {
+	plugin Plugin
+	state  string
+	err    error // why Init failed
+}
+
+var plugins []*pluginStatus
+var pluginLock sync.Mutex
+
+// addPlugin registers a plugin, to be initialized by Initialize.
+func addPlugin(p Plugin) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+
+	plugins = append(plugins, &pluginStatus{plugin: p, state: pluginRegistered})
+}
+
+// registeredPlugins returns a copy of plugin list, so plugins can be called
+// without holding pluginLock.
+func registeredPlugins() []*pluginStatus {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+
+	return append([]*pluginStatus(nil), plugins...)
+}
+
+func setPluginState(s *pluginStatus, state string, err error) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+
+	s.state = state
+	s.err = err
+}
+
+// initPlugin initializes a plugin, unless it's in global "DisabledPlugins".
+func initPlugin(ctx context.Context, s *pluginStatus) {
+	name := s.plugin.Name()
+	if !pluginEnabled(nil, name) {
+		log.Println("plugin", name, "disabled")
+		setPluginState(s, pluginDisabled, nil)
+		return
+	}
+
+	log.Println("initializing plugin", name)
+	if err := s.plugin.Init(ctx, cfg); err != nil {
+		log.Println("plugin", name, "failed to initialize:", err)
+		setPluginState(s, pluginFailed, err)
+		return
+	}
+	setPluginState(s, pluginLoaded, nil)
+}
+
+// closePlugins closes loaded plugins, in reverse order of registration.
+func closePlugins() {
+	list := registeredPlugins()
+	for i := len(list) - 1; i >= 0; i-- {
+		s := list[i]
+		pluginLock.Lock()
+		loaded := s.state == pluginLoaded
+		pluginLock.Unlock()
+		if !loaded {
+			continue
+		}
+
+		if err := s.plugin.Close(); err != nil {
+			log.Println("plugin", s.plugin.Name(), "error closing:", err)
+		}
+		setPluginState(s, pluginRegistered, nil)
+	}
+}
+
+// pluginReport describes state of every plugin: those implementing Plugin,
+// and those that just registered callbacks or commands, which are either
+// enabled or disabled globally.
+func pluginReport() []string {
+	var r []string
+	reported := make(map[string]bool)
+	for _, s := range registeredPlugins() {
+		reported[s.plugin.Name()] = true
+		pluginLock.Lock()
+		state, err := s.state, s.err
+		pluginLock.Unlock()
+
+		name := s.plugin.Name()
+		switch {
+		case state == pluginFailed:
+			r = append(r, name+": failed: "+err.Error())
+		case state != pluginLoaded:
+			r = append(r, name+": "+state)
+		default:
+			if err := s.plugin.Health(); err != nil {
+				r = append(r, name+": unhealthy: "+err.Error())
+			} else {
+				r = append(r, name+": ok")
+			}
+		}
+	}
+
+	for _, name := range callbackNames() {
+		switch {
+		case reported[name]:
+		case pluginEnabled(nil, name):
+			r = append(r, name+": enabled")
+		default:
+			r = append(r, name+": disabled")
+		}
+	}
+	return r
+}
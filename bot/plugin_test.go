@@ -0,0 +1,104 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arachnist/dyncfg"
+)
+
+type testPlugin struct {
+	name    string
+	initErr error
+	health  error
+	closed  *[]string
+}
+
+func (p testPlugin) Name() string { return p.name }
+
+func (p testPlugin) Init(ctx context.Context, cfg *dyncfg.Dyncfg) error { return p.initErr }
+
+func (p testPlugin) Close() error {
+	*p.closed = append(*p.closed, p.name)
+	return nil
+}
+
+func (p testPlugin) Health() error { return p.health }
+
+func TestPluginLifecycle(t *testing.T) {
+	pluginLock.Lock()
+	saved := plugins
+	plugins = nil
+	pluginLock.Unlock()
+	callbackLock.Lock()
+	savedCallbacks := callbacks
+	callbacks = make(map[string][]*registration)
+	callbackLock.Unlock()
+	defer func() {
+		pluginLock.Lock()
+		plugins = saved
+		pluginLock.Unlock()
+		callbackLock.Lock()
+		callbacks = savedCallbacks
+		callbackLock.Unlock()
+	}()
+
+	var closed []string
+	addPlugin(testPlugin{name: "first", closed: &closed})
+	addPlugin(testPlugin{name: "broken", initErr: errors.New("no such file"), closed: &closed})
+	addPlugin(testPlugin{name: "sick", health: errors.New("backend down"), closed: &closed})
+	addPlugin(testPlugin{name: "last", closed: &closed})
+
+	// Plugins with callbacks only are reported too, once.
+	addEventCallback("PRIVMSG", "first", func(e *Event) {})
+	addEventCallback("PRIVMSG", "simple", func(e *Event) {})
+	addEventCallback("JOIN", "simple", func(e *Event) {})
+	addEventCallback("PRIVMSG", "panicky", func(e *Event) {})
+	defer resetPanics("panicky")
+	for i := 0; i < defaultPanicLimit; i++ {
+		recordPanic(nil, "panicky")
+	}
+
+	for _, s := range registeredPlugins() {
+		initPlugin(context.Background(), s)
+	}
+
+	expected := []string{
+		"first: ok",
+		"broken: failed: no such file",
+		"sick: unhealthy: backend down",
+		"last: ok",
+		"panicky: disabled",
+		"simple: enabled",
+	}
+	if r := pluginReport(); fmt.Sprint(r) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, r)
+	}
+
+	closePlugins()
+	if e := []string{"last", "sick", "first"}; fmt.Sprint(closed) != fmt.Sprint(e) {
+		t.Errorf("expected loaded plugins to be closed in reverse order %v, got %v", e, closed)
+	}
+}
+
+func TestPluginsLoaded(t *testing.T) {
+	for _, r := range pluginReport() {
+		if r != "jan: ok" && r != "papiez: ok" && r != "seen: ok" && r != "chanlog: ok" && !strings.HasSuffix(r, ": enabled") {
+			t.Error("unexpected plugin state:", r)
+		}
+	}
+
+	report := strings.Join(pluginReport(), "\n") + "\n"
+	for _, e := range []string{"kotki: enabled\n", "roll: enabled\n", "LINKTITLE: enabled\n"} {
+		if !strings.Contains(report, e) {
+			t.Errorf("%q not reported", e)
+		}
+	}
+}
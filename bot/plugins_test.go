@@ -7,6 +7,7 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -43,6 +44,24 @@ var eventTests = []struct {
 			},
 		},
 	},
+	{
+		desc: "seen without arguments",
+		in: irc.Message{
+			Command:  "PRIVMSG",
+			Trailing: ":seen",
+			Params:   []string{"#testchan-1"},
+			Prefix: &irc.Prefix{
+				Name: "idontexist",
+			},
+		},
+		expectedOut: []irc.Message{
+			{
+				Command:  "PRIVMSG",
+				Params:   []string{"#testchan-1"},
+				Trailing: "Usage: :seen <nick>",
+			},
+		},
+	},
 	{
 		desc: "ping",
 		in: irc.Message{
@@ -568,17 +587,6 @@ var noResponseEvents = []struct {
 			},
 		},
 	},
-	{
-		desc: "seen without arguments",
-		in: irc.Message{
-			Command:  "PRIVMSG",
-			Trailing: ":seen",
-			Params:   []string{"#testchan-1"},
-			Prefix: &irc.Prefix{
-				Name: "idontexist",
-			},
-		},
-	},
 }
 
 func TestNoResponse(t *testing.T) {
@@ -738,7 +746,7 @@ func TestSeenConditions(t *testing.T) {
 
 	wg.Add(len(seenTests))
 	for _, e := range seenTests {
-		seen(genOutTestFunction(e.outRegex), e.in)
+		commandCallback("seen")(context.Background(), genOutTestFunction(e.outRegex), e.in)
 	}
 
 	wg.Wait()
@@ -747,7 +755,7 @@ func TestSeenConditions(t *testing.T) {
 var variableOutputTestEvents = []struct {
 	in       irc.Message
 	outRegex regexp.Regexp
-	function func(func(irc.Message), irc.Message)
+	function callback
 }{
 	{
 		in: irc.Message{
@@ -759,7 +767,7 @@ var variableOutputTestEvents = []struct {
 			},
 		},
 		outRegex: *regexp.MustCompile("^http://.*tumblr"),
-		function: kotki,
+		function: commandCallback("kotki"),
 	},
 	{
 		in: irc.Message{
@@ -771,7 +779,7 @@ var variableOutputTestEvents = []struct {
 			},
 		},
 		outRegex: *regexp.MustCompile("^cycki [(]nsfw[)]: http://.*"),
-		function: cycki,
+		function: commandCallback("cycki"),
 	},
 	{
 		in: irc.Message{
@@ -783,7 +791,7 @@ var variableOutputTestEvents = []struct {
 			},
 		},
 		outRegex: *regexp.MustCompile("^bonjour [(]nsfw[)]: http://.*"),
-		function: bonjour,
+		function: commandCallback("bonjour"),
 	},
 	{
 		in: irc.Message{
@@ -795,7 +803,7 @@ var variableOutputTestEvents = []struct {
 			},
 		},
 		outRegex: *regexp.MustCompile("^."),
-		function: korwin,
+		function: commandCallback("korwin"),
 	},
 	{
 		in: irc.Message{
@@ -807,7 +815,7 @@ var variableOutputTestEvents = []struct {
 			},
 		},
 		outRegex: *regexp.MustCompile("^at:"),
-		function: at,
+		function: commandCallback("at"),
 	},
 	{
 		in: irc.Message{
@@ -851,12 +859,20 @@ func TestFunctionsWithVariableOutput(t *testing.T) {
 
 	wg.Add(len(variableOutputTestEvents))
 	for _, e := range variableOutputTestEvents {
-		e.function(genOutTestFunction(e.outRegex), e.in)
+		e.function(context.Background(), genOutTestFunction(e.outRegex), e.in)
 	}
 
 	wg.Wait()
 }
 
+// commandCallback returns a callback running command name, so tests can run
+// commands without going through Dispatcher.
+func commandCallback(name string) callback {
+	return func(ctx context.Context, output func(irc.Message), msg irc.Message) {
+		lookupCommand(name).callback(ctx, output, msg)
+	}
+}
+
 func configLookupHelper(map[string]string) []string {
 	return []string{".testconfig.json"}
 }
@@ -0,0 +1,215 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+// Scopes rate limits can be configured for, named after message context keys.
+// Network limits apply to everyone on the network.
+var rateLimitScopes = []string{"Source", "Target", "Network"}
+
+// Sent, followed by time left, to users hitting a rate limit, if
+// "RateLimitMessage" isn't configured.
+const defaultRateLimitMessage = "Slow down! Try again in"
+
+// How often idle buckets are dropped.
+const rateLimitPurgeInterval = 10 * time.Minute
+
+// rateLimit allows count calls per period.
+type rateLimit struct {
+	count  int
+	period time.Duration
+}
+
+// bucket is a token bucket, refilled at limit.count tokens per limit.period.
+type bucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+	warned bool // we've told the user to slow down since their last call
+}
+
+// refill adds tokens for time passed since last refill.
+func (b *bucket) refill(now time.Time) {
+	b.tokens += float64(b.limit.count) * float64(now.Sub(b.last)) / float64(b.limit.period)
+	if b.tokens > float64(b.limit.count) {
+		b.tokens = float64(b.limit.count)
+	}
+	b.last = now
+}
+
+// wait returns how long it'll take to get a token.
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.limit.period) / float64(b.limit.count))
+}
+
+// idle tells if bucket is full, so forgetting it changes nothing.
+func (b *bucket) idle(now time.Time) bool {
+	return now.Sub(b.last) >= b.limit.period
+}
+
+type rateLimiter struct {
+	l         sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+}
+
+var limiter = rateLimiter{buckets: make(map[string]*bucket)}
+
+// take takes a token from each of buckets under keys, if all of them have
+// one. Otherwise returns how long to wait, and whether the user should be
+// warned about it; they're warned once until their next successful call.
+func (r *rateLimiter) take(keys []string, limits []rateLimit, now time.Time) (wait time.Duration, warn bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if now.Sub(r.lastPurge) >= rateLimitPurgeInterval {
+		for k, b := range r.buckets {
+			if b.idle(now) {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastPurge = now
+	}
+
+	buckets := make([]*bucket, len(keys))
+	for i, k := range keys {
+		b, ok := r.buckets[k]
+		if !ok || b.limit != limits[i] {
+			b = &bucket{limit: limits[i], tokens: float64(limits[i].count), last: now}
+			r.buckets[k] = b
+		}
+		b.refill(now)
+		if w := b.wait(); w > wait {
+			wait = w
+		}
+		buckets[i] = b
+	}
+
+	if wait > 0 {
+		for _, b := range buckets {
+			if !b.warned {
+				warn = true
+			}
+			b.warned = true
+		}
+		return wait, warn
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+		b.warned = false
+	}
+	return 0, false
+}
+
+// rateLimits returns limits configured for plugin in context, with
+// "RateLimits", which maps plugin names (or "*" for all plugins) to limits
+// for each scope, as [count, seconds]. For example:
+//
+//	"RateLimits":{"kotki":{"Source":[2, 60], "Target":[10, 60]}}
+func rateLimits(context map[string]string, plugin string) map[string]rateLimit {
+	all, _ := cfg.Lookup(context, "RateLimits").(map[string]interface{})
+	configured, ok := all[plugin].(map[string]interface{})
+	if !ok {
+		configured, _ = all["*"].(map[string]interface{})
+	}
+
+	r := make(map[string]rateLimit)
+	for _, scope := range rateLimitScopes {
+		v, _ := configured[scope].([]interface{})
+		if len(v) != 2 {
+			continue
+		}
+		count, _ := v[0].(float64)
+		seconds, _ := v[1].(float64)
+		if count < 1 || seconds <= 0 {
+			continue
+		}
+		r[scope] = rateLimit{
+			count:  int(count),
+			period: time.Duration(seconds * float64(time.Second)),
+		}
+	}
+	return r
+}
+
+// rateLimitBypass tells if author of msg has one of "RateLimitBypass" roles,
+// admin by default.
+func rateLimitBypass(msg irc.Message) bool {
+	roles := cfg.LookupStringSlice(msg.Context, "RateLimitBypass")
+	if len(roles) == 0 {
+		roles = []string{adminRole}
+	}
+	for _, role := range roles {
+		if hasRole(msg, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey returns key of the bucket msg takes a token from, for scope of
+// plugin's limits. Private messages are all sent to our nick, so their Target
+// limits apply to each sender separately.
+func rateLimitKey(msg irc.Message, plugin, scope string) string {
+	server := isupport(msg.Context["Network"])
+	var value string
+	switch {
+	case scope == "Network":
+	case scope == "Target" && !server.IsChannel(msg.Context["Target"]):
+		value = server.Fold(msg.Context["Source"])
+	default:
+		value = server.Fold(msg.Context[scope])
+	}
+	return strings.Join([]string{plugin, scope, msg.Context["Network"], value}, "\x00")
+}
+
+// rateLimited checks if msg is over any of rate limits configured for plugin,
+// and tells its author to slow down, if it is.
+func rateLimited(output func(irc.Message), msg irc.Message, plugin string) bool {
+	limits := rateLimits(msg.Context, plugin)
+	if len(limits) == 0 || rateLimitBypass(msg) {
+		return false
+	}
+
+	var keys []string
+	var l []rateLimit
+	for _, scope := range rateLimitScopes {
+		limit, ok := limits[scope]
+		if !ok {
+			continue
+		}
+		keys = append(keys, rateLimitKey(msg, plugin, scope))
+		l = append(l, limit)
+	}
+
+	wait, warn := limiter.take(keys, l, time.Now())
+	if wait == 0 {
+		return false
+	}
+
+	log.Println("Context:", msg.Context, "Rate limit exceeded for", plugin)
+	if warn && msg.Prefix != nil {
+		text := cfg.LookupString(msg.Context, "RateLimitMessage")
+		if text == "" {
+			text = defaultRateLimitMessage
+		}
+		// Round up, so we never say "0s".
+		wait = (wait + time.Second - 1) / time.Second * time.Second
+		output(privateNotice(msg, text+" "+wait.String()))
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := rateLimiter{buckets: make(map[string]*bucket)}
+	now := time.Now()
+	user := rateLimit{count: 2, period: 10 * time.Second}
+	channel := rateLimit{count: 3, period: time.Minute}
+	keys := []string{"user", "channel"}
+	limits := []rateLimit{user, channel}
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := r.take(keys, limits, now); wait != 0 {
+			t.Fatalf("call %d shouldn't be limited, got wait %v", i, wait)
+		}
+	}
+
+	wait, warn := r.take(keys, limits, now)
+	if wait != 5*time.Second || !warn {
+		t.Errorf("expected to wait 5s, with a warning, got %v, %v", wait, warn)
+	}
+	if _, warn := r.take(keys, limits, now); warn {
+		t.Error("expected a single warning")
+	}
+
+	now = now.Add(5 * time.Second)
+	if wait, _ := r.take(keys, limits, now); wait != 0 {
+		t.Errorf("expected a token after 5s, got wait %v", wait)
+	}
+
+	// User has a token again, but the channel ran out of them.
+	now = now.Add(5 * time.Second)
+	wait, warn = r.take(keys, limits, now)
+	if wait <= 0 || !warn {
+		t.Errorf("expected channel limit to apply, got %v, %v", wait, warn)
+	}
+	if r.buckets["user"].tokens < 1 {
+		t.Error("limited call shouldn't use up tokens")
+	}
+
+	r.take(nil, nil, now.Add(time.Hour))
+	if len(r.buckets) != 0 {
+		t.Errorf("expected idle buckets to be dropped, got %d", len(r.buckets))
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	key := func(source, target, scope string) string {
+		return rateLimitKey(irc.Message{
+			Context: map[string]string{"Network": "TestNet", "Source": source, "Target": target},
+		}, "plugin", scope)
+	}
+
+	if key("a", "#Chan", "Target") != key("b", "#chan", "Target") {
+		t.Error("users in the same channel should share Target limits")
+	}
+	if key("a", "gorepost", "Target") == key("b", "gorepost", "Target") {
+		t.Error("users sending private messages shouldn't share Target limits")
+	}
+	if key("a", "#chan", "Network") != key("b", "gorepost", "Network") {
+		t.Error("everyone should share Network limits")
+	}
+	if key("A", "#chan", "Source") != key("a", "#other", "Source") {
+		t.Error("Source limits should follow the user")
+	}
+}
+
+func TestDispatcherRateLimit(t *testing.T) {
+	limiter.l.Lock()
+	limiter.buckets = make(map[string]*bucket)
+	limiter.l.Unlock()
+
+	var r []irc.Message
+	var m sync.Mutex
+	output := func(msg irc.Message) {
+		m.Lock()
+		defer m.Unlock()
+		r = append(r, msg)
+	}
+
+	ping := func(nick, account string) {
+		Dispatcher(output, irc.Message{
+			Context:  map[string]string{"Source": nick, "Target": "#testchan-1"},
+			Tags:     map[string]string{"account": account},
+			Command:  "PRIVMSG",
+			Params:   []string{"#testchan-1"},
+			Trailing: ":ping",
+			Prefix:   &irc.Prefix{Name: nick, User: "u", Host: "h"},
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 4; i++ {
+		ping("spammer", "")
+	}
+	for i := 0; i < 4; i++ {
+		ping("admin", "admin-account")
+	}
+	running.Wait()
+
+	var pongs, notices int
+	for _, msg := range r {
+		switch {
+		case msg.Command == "PRIVMSG" && msg.Trailing == "pingity pong":
+			pongs++
+		case msg.Command == "NOTICE" && msg.Params[0] == "spammer":
+			notices++
+		default:
+			t.Errorf("unexpected message: %+v", msg)
+		}
+	}
+	if pongs != 6 || notices != 1 {
+		t.Errorf("expected 2 replies to spammer, 4 to admin, and a single warning, got %d replies, %d warnings", pongs, notices)
+	}
+}
@@ -5,46 +5,32 @@
 package bot
 
 import (
+	"context"
 	"math/rand"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-func roll(output func(irc.Message), msg irc.Message) {
-	args := strings.Split(msg.Trailing, " ")
-	if args[0] != ":roll" {
-		return
-	}
-
+func roll(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var err error
 	rolls := 1
 
-	if len(args) == 3 {
-		rolls, err = strconv.Atoi(args[2])
+	if len(args) == 2 {
+		rolls, err = strconv.Atoi(args[1])
 		if err != nil || rolls < 1 {
-			output(reply(msg, "Usage: :roll <sides int> <rolls int>, each roll is [0, n)+1, size has to be >0"))
-			return
+			return errUsage
 		}
-	} else if len(args) != 2 {
-		output(reply(msg, "Usage: :roll <sides int> <rolls int>, each roll is [0, n)+1, size has to be >0"))
-		return
 	}
 
-	sides, err := strconv.Atoi(args[1])
-	if err != nil {
-		output(reply(msg, "Usage: :roll <sides int> <rolls int>, each roll is [0, n)+1, size has to be >0"))
-		return
-	}
-	if sides <= 0 {
-		output(reply(msg, "Usage: :roll <sides int> <rolls int>, each roll is [0, n)+1, size has to be >0"))
-		return
+	sides, err := strconv.Atoi(args[0])
+	if err != nil || sides <= 0 {
+		return errUsage
 	}
 	if sides > 1000000 || rolls > 1000000 {
 		output(reply(msg, "Number of rolls and dice size is limited to 1000000"))
-		return
+		return nil
 	}
 
 	sum := rolls
@@ -53,9 +39,16 @@ func roll(output func(irc.Message), msg irc.Message) {
 	}
 
 	output(reply(msg, strconv.Itoa(sum)))
+	return nil
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-	addCallback("PRIVMSG", "roll", roll)
+	addCommand(&command{
+		name:        "roll",
+		args:        "<sides> [rolls]",
+		usage:       "<sides int> <rolls int>, each roll is [0, n)+1, size has to be >0",
+		description: "Rolls dice, and sums up the results",
+		run:         roll,
+	})
 }
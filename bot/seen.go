@@ -5,19 +5,17 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
-	"github.com/cloudflare/gokabinet/kt"
+	"github.com/arachnist/dyncfg"
 
 	"github.com/arachnist/gorepost/irc"
 )
 
-var k *kt.Conn
-
 type seenRecord struct {
 	Network string
 	Target  string
@@ -45,37 +43,31 @@ func seenrecord(output func(irc.Message), msg irc.Message) {
 
 	b, _ := json.Marshal(v)
 
-	err := k.Set("seen/"+msg.Prefix.Name, b)
+	err := store.Set("seen", seenKey(msg.Context, msg.Prefix.Name), b, 0)
 	if err != nil {
 		log.Println("Context:", msg.Context, "error recording seen record:", err)
 	}
 }
 
-func seen(output func(irc.Message), msg irc.Message) {
+func seen(ctx context.Context, output func(irc.Message), msg irc.Message, args []string) error {
 	var v seenRecord
 	var r string
 
-	args := strings.Split(msg.Trailing, " ")
-
-	if args[0] != ":seen" {
-		return
-	}
-	if len(args) < 2 {
-		return
-	}
-
-	b, err := k.GetBytes("seen/" + args[1])
-	if err == kt.ErrNotFound {
+	b, err := seenGet(msg.Context, args[0])
+	if err == errNotFound {
 		output(reply(msg, cfg.LookupString(msg.Context, "NotSeenMessage")))
-		return
+		return nil
 	} else if err != nil {
-		output(reply(msg, fmt.Sprint("error getting record for", args[1], err)))
-		return
+		output(reply(msg, fmt.Sprint("error getting record for", args[0], err)))
+		return nil
 	}
 
-	_ = json.Unmarshal(b, &v)
+	if err := json.Unmarshal(b, &v); err != nil {
+		output(reply(msg, fmt.Sprint("error decoding record for ", args[0], ": ", err)))
+		return nil
+	}
 
-	r = fmt.Sprintf("Last seen %s on %s/%s at %v ", args[1], v.Network, v.Target, v.Time.Round(time.Second))
+	r = fmt.Sprintf("Last seen %s on %s/%s at %v ", args[0], v.Network, v.Target, v.Time.Round(time.Second))
 
 	switch v.Action {
 	case "JOIN":
@@ -91,30 +83,58 @@ func seen(output func(irc.Message), msg irc.Message) {
 	}
 
 	output(reply(msg, r))
+	return nil
 }
 
-func seenInit() {
-	var err error
-	var ktHost = cfg.LookupString(nil, "KTHost")
-	var ktPort = cfg.LookupInt(nil, "KTPort")
+// seenKey returns the key seen records for nick are stored under. Nicks are
+// folded using network's case mapping, so lookups are case-insensitive.
+func seenKey(context map[string]string, nick string) string {
+	return isupport(context["Network"]).Fold(nick)
+}
 
-	log.Println("seen: connecting to KT")
-	k, err = kt.NewConn(ktHost, ktPort, 4, 2*time.Second)
-	if err != nil {
-		log.Println("error connecting to kyoto tycoon", err)
-		return
+// seenGet returns the seen record for nick. Records written before keys were
+// folded are stored under nick as it was, so they're looked up by nick as
+// given, if there's no folded record.
+func seenGet(context map[string]string, nick string) ([]byte, error) {
+	key := seenKey(context, nick)
+	b, err := store.Get("seen", key)
+	if err == errNotFound && key != nick {
+		return store.Get("seen", nick)
 	}
+	return b, err
+}
+
+// seenPlugin keeps seen records in the store.
+type seenPlugin struct{}
 
-	log.Println("Registering callbacks")
-	addCallback("PRIVMSG", "seen", seen)
+func (seenPlugin) Name() string { return "seen" }
+
+func (seenPlugin) Init(ctx context.Context, cfg *dyncfg.Dyncfg) error {
+	addCommand(&command{
+		name:        "seen",
+		args:        "<nick>",
+		description: "Tells when nick was last seen, and what they were doing",
+		run:         seen,
+	})
 	addCallback("PRIVMSG", "seenrecord", seenrecord)
 	addCallback("JOIN", "seenrecord", seenrecord)
 	addCallback("PART", "seenrecord", seenrecord)
 	addCallback("QUIT", "seenrecord", seenrecord)
 	addCallback("NOTICE", "seenrecord", seenrecord)
+	return nil
+}
+
+func (seenPlugin) Close() error { return nil }
+
+// Health reports records being kept in memory only, if configured store
+// couldn't be opened.
+func (seenPlugin) Health() error {
+	if storeErr != nil {
+		return fmt.Errorf("records won't persist, store unavailable: %v", storeErr)
+	}
+	return nil
 }
 
 func init() {
-	log.Println("Defering \"seen\" initialization")
-	addInit(seenInit)
+	addPlugin(seenPlugin{})
 }
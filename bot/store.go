@@ -0,0 +1,188 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// errNotFound is returned by Store.Get for keys that don't exist, or have
+// expired.
+var errNotFound = errors.New("store: key not found")
+
+// Store is a key/value store, used by plugins that need to keep state around
+// between restarts. Keys live in namespaces, usually one per plugin. A ttl of
+// zero means the value never expires.
+type Store interface {
+	Get(namespace, key string) ([]byte, error)
+	Set(namespace, key string, value []byte, ttl time.Duration) error
+	Delete(namespace, key string) error
+	// Update atomically replaces value of key with the one returned by f.
+	// f gets nil if there's no value yet. If f returns an error, value is
+	// left alone, and the error is returned.
+	Update(namespace, key string, ttl time.Duration, f func([]byte) ([]byte, error)) error
+	Close() error
+}
+
+var store Store
+
+// storeErr is why configured store couldn't be opened, if it couldn't.
+var storeErr error
+
+// openStore sets up the store selected with "StoreBackend": "bolt" (file at
+// "StorePath"), "kt" (Kyoto Tycoon at "KTHost":"KTPort") or "memory". If it's
+// not set, Kyoto Tycoon is used when "KTHost" is configured, memory
+// otherwise. If the store can't be opened, we fall back to memory, so
+// plugins keep working, even if they forget things on restart.
+func openStore() Store {
+	storeErr = nil
+	backend := cfg.LookupString(nil, "StoreBackend")
+	if backend == "" {
+		backend = "memory"
+		if cfg.LookupString(nil, "KTHost") != "" {
+			backend = "kt"
+		}
+	}
+
+	var s Store
+	var err error
+	switch backend {
+	case "bolt":
+		s, err = newBoltStore(cfg.LookupString(nil, "StorePath"))
+	case "kt":
+		s, err = newKTStore(cfg.LookupString(nil, "KTHost"), cfg.LookupInt(nil, "KTPort"))
+	case "memory":
+		return newMemoryStore()
+	default:
+		err = errors.New("unknown backend " + backend)
+	}
+	if err != nil {
+		log.Println("store: error opening", backend, "store:", err, "falling back to memory")
+		storeErr = err
+		return newMemoryStore()
+	}
+
+	log.Println("store: using", backend, "backend")
+	return s
+}
+
+// expiry returns the time a value stored with ttl expires at, or zero time if
+// it doesn't.
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// expired tells if a value with expiry time at is gone.
+func expired(at time.Time) bool {
+	return !at.IsZero() && time.Now().After(at)
+}
+
+// wrapValue prepends value with its expiry time, for backends without
+// support for expiration.
+func wrapValue(value []byte, ttl time.Duration) []byte {
+	var at int64
+	if e := expiry(ttl); !e.IsZero() {
+		at = e.UnixNano()
+	}
+	b := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(b, uint64(at))
+	copy(b[8:], value)
+	return b
+}
+
+// unwrapValue is the reverse of wrapValue. Returns errNotFound if the value
+// expired.
+func unwrapValue(b []byte) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, errors.New("store: value too short")
+	}
+	if at := int64(binary.BigEndian.Uint64(b)); at != 0 && expired(time.Unix(0, at)) {
+		return nil, errNotFound
+	}
+	return b[8:], nil
+}
+
+type memoryValue struct {
+	value  []byte
+	expiry time.Time
+}
+
+// memoryStore keeps everything in memory. Used in tests, and when no other
+// backend is available.
+type memoryStore struct {
+	l    sync.Mutex
+	data map[string]map[string]memoryValue
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: make(map[string]map[string]memoryValue)}
+}
+
+// get has to be called with s.l held.
+func (s *memoryStore) get(namespace, key string) ([]byte, error) {
+	v, ok := s.data[namespace][key]
+	if !ok || expired(v.expiry) {
+		return nil, errNotFound
+	}
+	return append([]byte(nil), v.value...), nil
+}
+
+// set has to be called with s.l held.
+func (s *memoryStore) set(namespace, key string, value []byte, ttl time.Duration) {
+	if s.data[namespace] == nil {
+		s.data[namespace] = make(map[string]memoryValue)
+	}
+	s.data[namespace][key] = memoryValue{
+		value:  append([]byte(nil), value...),
+		expiry: expiry(ttl),
+	}
+}
+
+func (s *memoryStore) Get(namespace, key string) ([]byte, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.get(namespace, key)
+}
+
+func (s *memoryStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.set(namespace, key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Delete(namespace, key string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.data[namespace], key)
+	return nil
+}
+
+func (s *memoryStore) Update(namespace, key string, ttl time.Duration, f func([]byte) ([]byte, error)) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	old, err := s.get(namespace, key)
+	if err != nil && err != errNotFound {
+		return err
+	}
+	value, err := f(old)
+	if err != nil {
+		return err
+	}
+	s.set(namespace, key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
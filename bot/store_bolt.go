@@ -0,0 +1,98 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps data in a bbolt database file, with a bucket for every
+// namespace.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	if path == "" {
+		return nil, errors.New("StorePath not configured")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// get has to be called within a transaction.
+func (s *boltStore) get(tx *bolt.Tx, namespace, key string) ([]byte, error) {
+	b := tx.Bucket([]byte(namespace))
+	if b == nil {
+		return nil, errNotFound
+	}
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, errNotFound
+	}
+	v, err := unwrapValue(v)
+	if err != nil {
+		return nil, err
+	}
+	// Values are only valid within the transaction.
+	return append([]byte(nil), v...), nil
+}
+
+// set has to be called within a writable transaction.
+func (s *boltStore) set(tx *bolt.Tx, namespace, key string, value []byte, ttl time.Duration) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(namespace))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), wrapValue(value, ttl))
+}
+
+func (s *boltStore) Get(namespace, key string) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value, err = s.get(tx, namespace, key)
+		return err
+	})
+	return
+}
+
+func (s *boltStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.set(tx, namespace, key, value, ttl)
+	})
+}
+
+func (s *boltStore) Delete(namespace, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(namespace))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) Update(namespace, key string, ttl time.Duration, f func([]byte) ([]byte, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, err := s.get(tx, namespace, key)
+		if err != nil && err != errNotFound {
+			return err
+		}
+		value, err := f(old)
+		if err != nil {
+			return err
+		}
+		return s.set(tx, namespace, key, value, ttl)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
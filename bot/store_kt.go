@@ -0,0 +1,124 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/gokabinet/kt"
+)
+
+// ktStore keeps data in Kyoto Tycoon, under "namespace/key" keys.
+//
+// Values are prefixed with ktMagic, and expiry time. Values without the
+// prefix were stored by older versions, as is, and never expire.
+//
+// Kyoto Tycoon has no compare-and-swap we could use, so Update is only atomic
+// with respect to other updates made by this process.
+type ktStore struct {
+	l    sync.Mutex
+	conn *kt.Conn
+}
+
+func newKTStore(host string, port int) (*ktStore, error) {
+	conn, err := kt.NewConn(host, port, 4, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &ktStore{conn: conn}, nil
+}
+
+var errKTClosed = errors.New("store: closed")
+
+// ktMagic starts values stored by ktStore. No JSON value starts with a NUL.
+const ktMagic = "\x00gorepost\x00"
+
+func ktKey(namespace, key string) string {
+	return namespace + "/" + key
+}
+
+func ktWrap(value []byte, ttl time.Duration) []byte {
+	return append([]byte(ktMagic), wrapValue(value, ttl)...)
+}
+
+// ktUnwrap is the reverse of ktWrap. Values stored without ktMagic are
+// returned as they are.
+func ktUnwrap(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, []byte(ktMagic)) {
+		return b, nil
+	}
+	return unwrapValue(b[len(ktMagic):])
+}
+
+// get has to be called with s.l held.
+func (s *ktStore) get(namespace, key string) ([]byte, error) {
+	if s.conn == nil {
+		return nil, errKTClosed
+	}
+	b, err := s.conn.GetBytes(ktKey(namespace, key))
+	if err == kt.ErrNotFound {
+		return nil, errNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return ktUnwrap(b)
+}
+
+func (s *ktStore) Get(namespace, key string) ([]byte, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.get(namespace, key)
+}
+
+func (s *ktStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.conn == nil {
+		return errKTClosed
+	}
+	return s.conn.Set(ktKey(namespace, key), ktWrap(value, ttl))
+}
+
+func (s *ktStore) Delete(namespace, key string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.conn == nil {
+		return errKTClosed
+	}
+
+	err := s.conn.Remove(ktKey(namespace, key))
+	if err == kt.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *ktStore) Update(namespace, key string, ttl time.Duration, f func([]byte) ([]byte, error)) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	old, err := s.get(namespace, key)
+	if err != nil && err != errNotFound {
+		return err
+	}
+	value, err := f(old)
+	if err != nil {
+		return err
+	}
+	return s.conn.Set(ktKey(namespace, key), ktWrap(value, ttl))
+}
+
+// Close drops the connection pool, so plugins still running after Shutdown
+// can't write to the store. Connections in the pool are closed when they're
+// garbage collected.
+func (s *ktStore) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.conn = nil
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	if _, err := s.Get("test", "missing"); err != errNotFound {
+		t.Errorf("expected errNotFound for missing key, got %v", err)
+	}
+
+	if err := s.Set("test", "key", []byte("value"), 0); err != nil {
+		t.Fatal("error setting key:", err)
+	}
+	if v, err := s.Get("test", "key"); err != nil || string(v) != "value" {
+		t.Errorf("expected value, got %q, %v", v, err)
+	}
+	if _, err := s.Get("other", "key"); err != errNotFound {
+		t.Errorf("namespaces should be separate, got %v", err)
+	}
+
+	if err := s.Set("test", "short", []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal("error setting key:", err)
+	}
+	if _, err := s.Get("test", "short"); err != nil {
+		t.Errorf("key expired too soon: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.Get("test", "short"); err != errNotFound {
+		t.Errorf("expected key to expire, got %v", err)
+	}
+
+	if err := s.Delete("test", "key"); err != nil {
+		t.Error("error deleting key:", err)
+	}
+	if _, err := s.Get("test", "key"); err != errNotFound {
+		t.Errorf("expected key to be deleted, got %v", err)
+	}
+	if err := s.Delete("nonexistent", "key"); err != nil {
+		t.Error("error deleting missing key:", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Update("test", "counter", 0, func(old []byte) ([]byte, error) {
+				return append(old, 'x'), nil
+			})
+			if err != nil {
+				t.Error("error updating key:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := s.Get("test", "counter"); len(v) != 20 {
+		t.Errorf("expected 20 updates, got %d", len(v))
+	}
+
+	fail := errors.New("fail")
+	err := s.Update("test", "counter", 0, func(old []byte) ([]byte, error) {
+		return nil, fail
+	})
+	if err != fail {
+		t.Errorf("expected error from update function, got %v", err)
+	}
+	if v, _ := s.Get("test", "counter"); len(v) != 20 {
+		t.Errorf("failed update shouldn't change value, got %q", v)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error("error closing store:", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newBoltStore(filepath.Join(dir, "store.db"))
+	if err != nil {
+		t.Fatal("error opening store:", err)
+	}
+	testStore(t, s)
+}
+
+func TestKTValues(t *testing.T) {
+	legacy := []byte(`{"Network":"TestNet","Target":"#testchan-1"}`)
+	if v, err := ktUnwrap(legacy); err != nil || string(v) != string(legacy) {
+		t.Errorf("legacy value should be returned as is, got %q, %v", v, err)
+	}
+
+	if v, err := ktUnwrap(ktWrap([]byte("value"), 0)); err != nil || string(v) != "value" {
+		t.Errorf("expected value, got %q, %v", v, err)
+	}
+
+	b := ktWrap([]byte("value"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := ktUnwrap(b); err != errNotFound {
+		t.Errorf("expected value to expire, got %v", err)
+	}
+}
+
+func TestSeenLegacyKey(t *testing.T) {
+	saved := store
+	store = newMemoryStore()
+	defer func() { store = saved }()
+
+	context := map[string]string{"Network": "TestNet"}
+	store.Set("seen", "Legacy[Nick]", []byte("legacy"), 0)
+	if b, err := seenGet(context, "Legacy[Nick]"); err != nil || string(b) != "legacy" {
+		t.Errorf("expected legacy record, got %q, %v", b, err)
+	}
+
+	store.Set("seen", seenKey(context, "Legacy[Nick]"), []byte("current"), 0)
+	if b, err := seenGet(context, "LEGACY{NICK}"); err != nil || string(b) != "current" {
+		t.Errorf("expected current record, got %q, %v", b, err)
+	}
+	if b, err := seenGet(context, "Legacy[Nick]"); err != nil || string(b) != "current" {
+		t.Errorf("expected current record to take precedence, got %q, %v", b, err)
+	}
+}
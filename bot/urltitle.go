@@ -5,6 +5,7 @@
 package bot
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -26,10 +27,10 @@ var trimTitle = regexp.MustCompile("[\\s]+")
 var trimLink = regexp.MustCompile("^.*?http")
 var enc = charmap.ISO8859_2
 
-func youtube(vid string) string {
+func youtube(ctx context.Context, vid string) string {
 	var dat map[string]interface{}
 	link := fmt.Sprintf("https://www.youtube.com/oembed?format=json&url=http://www.youtube.com/watch?v=%+v", vid)
-	data, err := httpGet(link)
+	data, err := httpGet(ctx, link)
 	if err != nil {
 		return "error getting data from youtube"
 	}
@@ -41,25 +42,25 @@ func youtube(vid string) string {
 	return fmt.Sprint(dat["title"].(string), " by ", dat["author_name"].(string))
 }
 
-func youtubeLong(l string) string {
+func youtubeLong(ctx context.Context, l string) string {
 	pattern := regexp.MustCompile(`/watch[?]v[=](?P<vid>[a-zA-Z0-9-_]+)`)
 	res := []byte{}
 	for _, s := range pattern.FindAllSubmatchIndex([]byte(l), -1) {
 		res = pattern.ExpandString(res, "$vid", l, s)
 	}
-	return youtube(string(res))
+	return youtube(ctx, string(res))
 }
 
-func youtubeShort(l string) string {
+func youtubeShort(ctx context.Context, l string) string {
 	pattern := regexp.MustCompile(`youtu.be/(?P<vid>[a-zA-Z0-9-_]+)`)
 	res := []byte{}
 	for _, s := range pattern.FindAllSubmatchIndex([]byte(l), -1) {
 		res = pattern.ExpandString(res, "$vid", l, s)
 	}
-	return youtube(string(res))
+	return youtube(ctx, string(res))
 }
 
-func fourchanscrape(l string) string {
+func fourchanscrape(ctx context.Context, l string) string {
 	h := sha1.New()
 	t, e := ioutil.TempFile("", "4scrape_")
 	ext := path.Ext(l)
@@ -68,7 +69,11 @@ func fourchanscrape(l string) string {
 	}
 	multiwriter := io.MultiWriter(h, t)
 
-	response, err := http.Get(l)
+	req, err := http.NewRequest("GET", l, nil)
+	if err != nil {
+		return "error while downloading url"
+	}
+	response, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "error while downloading url"
 	}
@@ -102,8 +107,8 @@ func fourchanscrape(l string) string {
 	return cfg.LookupString(nil, "FourChanLinkBase") + "/" + filename
 }
 
-func genericURLTitle(l string) string {
-	title, err := httpGetXpath(l, "//head/title")
+func genericURLTitle(ctx context.Context, l string) string {
+	title, err := httpGetXpath(ctx, l, "//head/title")
 	if err == errElementNotFound {
 		return "no title"
 	} else if err != nil {
@@ -123,7 +128,7 @@ func genericURLTitle(l string) string {
 
 var customDataFetchers = []struct {
 	re      *regexp.Regexp
-	fetcher func(l string) string
+	fetcher func(ctx context.Context, l string) string
 }{
 	{
 		re:      regexp.MustCompile("//(www.)?youtube.com/watch"),
@@ -143,7 +148,7 @@ var customDataFetchers = []struct {
 	},
 }
 
-func linktitle(output func(irc.Message), msg irc.Message) {
+func linktitle(ctx context.Context, output func(irc.Message), msg irc.Message) {
 	var r []string
 
 	for _, s := range strings.Split(strings.Trim(msg.Trailing, "\001"), " ") {
@@ -159,7 +164,7 @@ func linktitle(output func(irc.Message), msg irc.Message) {
 		FetchersLoop:
 			for _, d := range customDataFetchers {
 				if d.re.MatchString(s) {
-					t := d.fetcher(s)
+					t := d.fetcher(ctx, s)
 					if t != "no title" {
 						r = append(r, t)
 					}
@@ -177,5 +182,5 @@ func linktitle(output func(irc.Message), msg irc.Message) {
 }
 
 func init() {
-	addCallback("PRIVMSG", "LINKTITLE", linktitle)
+	addContextCallback("PRIVMSG", "LINKTITLE", linktitle)
 }
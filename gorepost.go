@@ -8,11 +8,13 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/arachnist/dyncfg"
 	"github.com/arachnist/gorepost/bot"
-	"github.com/arachnist/gorepost/irc"
 )
 
 func fileListFuncBuilder(basedir, common string) func(map[string]string) []string {
@@ -34,8 +36,23 @@ func fileListFuncBuilder(basedir, common string) func(map[string]string) []strin
 	}
 }
 
+// How long we wait for plugins to finish, if "ShutdownTimeout" is not
+// configured.
+const defaultShutdownTimeout = 10
+
+// shutdown quits all networks, and waits for plugins to finish. Returns false
+// if they didn't finish in time.
+func shutdown(cfg *dyncfg.Dyncfg, n *networkSet) bool {
+	n.stopAll()
+
+	timeout := cfg.LookupInt(nil, "ShutdownTimeout")
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return bot.Shutdown(time.Duration(timeout) * time.Second)
+}
+
 func main() {
-	var exit chan struct{}
 	context := make(map[string]string)
 
 	if len(os.Args) < 2 {
@@ -62,11 +79,39 @@ func main() {
 
 	log.Println("Configured networks:", len(networks), networks)
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+
 	bot.Initialize(cfg)
-	for i, conn := range make([]irc.Connection, len(networks)) {
-		conn := conn
-		log.Println("Setting up", networks[i], "connection")
-		conn.Setup(bot.Dispatcher, networks[i], cfg)
+	n := newNetworkSet(cfg)
+	bot.SetController(n)
+	n.startAll()
+
+loop:
+	for {
+		select {
+		case <-n.quit:
+			log.Println("Quit requested, shutting down")
+			break loop
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGUSR1:
+				for _, s := range n.Status() {
+					log.Println("status:", s)
+				}
+			case syscall.SIGHUP:
+				log.Println("Received", sig, "reloading configuration")
+				n.Reload()
+			default:
+				log.Println("Received", sig, "shutting down")
+				break loop
+			}
+		}
+	}
+
+	if !shutdown(cfg, n) {
+		log.Println("Plugins didn't finish in time")
+		os.Exit(1)
 	}
-	<-exit
+	log.Println("Bye!")
 }
@@ -0,0 +1,203 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Reconnection settings, used when they're not configured for a network. All
+// values are in seconds.
+const (
+	defaultReconnectMinDelay     = 2
+	defaultReconnectMaxDelay     = 300
+	defaultReconnectHealthyAfter = 120
+)
+
+// Status describes the state of connection to a network.
+type Status struct {
+	Connected      bool
+	Server         string    // server we're connected to, or tried last
+	ConnectedSince time.Time // zero if not connected
+	Attempts       int       // failed connection attempts since the last healthy connection
+	LastError      string
+	NextAttempt    time.Time // zero if we're connected, or reconnecting right away
+}
+
+// backoffState keeps track of connection attempts, so we don't hammer
+// networks that are down. Guarded by statusLock.
+type backoffState struct {
+	Status
+	next   int                  // index of the next server to try
+	failed map[string]time.Time // servers that failed recently, and when
+}
+
+// backoffDelay calculates how long to wait before the next connection attempt.
+// The delay doubles with every failed attempt, up to max, and only the first
+// half of it is fixed; the rest is random, so reconnects from many clients
+// don't line up.
+func backoffDelay(attempts int, min, max time.Duration) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// pick chooses the next server to connect to. Servers are tried in turns,
+// skipping those which failed within window. If all of them did, the one that
+// failed the longest time ago is picked.
+func (b *backoffState) pick(servers []string, now time.Time, window time.Duration) string {
+	if b.next >= len(servers) {
+		b.next = 0
+	}
+
+	best := -1
+	for i := 0; i < len(servers); i++ {
+		n := (b.next + i) % len(servers)
+		failed, ok := b.failed[servers[n]]
+		if !ok || now.Sub(failed) >= window {
+			best = n
+			break
+		}
+		if best < 0 || failed.Before(b.failed[servers[best]]) {
+			best = n
+		}
+	}
+
+	b.next = (best + 1) % len(servers)
+	return servers[best]
+}
+
+// fail records a failed connection attempt, or a connection that didn't stay
+// up long enough to be considered healthy.
+func (b *backoffState) fail(server, reason string, now time.Time) {
+	if b.failed == nil {
+		b.failed = make(map[string]time.Time)
+	}
+	b.failed[server] = now
+	b.Attempts++
+	b.LastError = reason
+	b.Connected = false
+	b.ConnectedSince = time.Time{}
+}
+
+// reconnectSettings reads "ReconnectMinDelay", "ReconnectMaxDelay" and
+// "ReconnectHealthyAfter", all in seconds.
+func (c *Connection) reconnectSettings() (min, max, healthy time.Duration) {
+	seconds := func(key string, def float64) time.Duration {
+		return time.Duration(c.lookupFloat(key, def) * float64(time.Second))
+	}
+	min = seconds("ReconnectMinDelay", defaultReconnectMinDelay)
+	max = seconds("ReconnectMaxDelay", defaultReconnectMaxDelay)
+	healthy = seconds("ReconnectHealthyAfter", defaultReconnectHealthyAfter)
+	if max < min {
+		max = min
+	}
+	return
+}
+
+// reconnectDelay returns how long Keeper should wait before connecting again,
+// and schedules the next attempt.
+func (c *Connection) reconnectDelay() time.Duration {
+	min, max, _ := c.reconnectSettings()
+
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	d := backoffDelay(c.backoff.Attempts, min, max)
+	if d > 0 {
+		c.backoff.NextAttempt = time.Now().Add(d)
+	} else {
+		c.backoff.NextAttempt = time.Time{}
+	}
+	return d
+}
+
+// pickServer chooses a server to connect to, from configured "Servers".
+func (c *Connection) pickServer(servers []string) string {
+	_, max, _ := c.reconnectSettings()
+
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	c.backoff.Server = c.backoff.pick(servers, time.Now(), max)
+	return c.backoff.Server
+}
+
+// dialFailed records a failed connection attempt.
+func (c *Connection) dialFailed(server string, err error) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	c.backoff.fail(server, err.Error(), time.Now())
+}
+
+// connected records a successful connection.
+func (c *Connection) connected(server string) {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	c.backoff.Connected = true
+	c.backoff.Server = server
+	c.backoff.ConnectedSince = time.Now()
+	c.backoff.NextAttempt = time.Time{}
+}
+
+// disconnected records a lost connection. If it stayed up long enough, backoff
+// is reset, so we reconnect right away.
+func (c *Connection) disconnected() {
+	_, _, healthy := c.reconnectSettings()
+
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	if !c.backoff.Connected {
+		return
+	}
+
+	if time.Since(c.backoff.ConnectedSince) >= healthy {
+		c.backoff.Attempts = 0
+		c.backoff.Connected = false
+		c.backoff.ConnectedSince = time.Time{}
+		delete(c.backoff.failed, c.backoff.Server)
+		return
+	}
+
+	log.Println(c.network, "connection to", c.backoff.Server, "didn't stay up long enough")
+	c.backoff.fail(c.backoff.Server, "connection lost shortly after connecting", time.Now())
+}
+
+// reconnectRequested records that the connection is going to be dropped on
+// purpose, so it doesn't count as a failure, and we reconnect right away.
+func (c *Connection) reconnectRequested() {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	c.backoff.Attempts = 0
+	c.backoff.Connected = false
+	c.backoff.ConnectedSince = time.Time{}
+}
+
+// Status returns current state of the connection.
+func (c *Connection) Status() Status {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+	return c.backoff.Status
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+var backoffTests = []struct {
+	attempts int
+	min, max time.Duration // bounds for the delay
+}{
+	{0, 0, 0},
+	{1, time.Second, 2 * time.Second},
+	{2, 2 * time.Second, 4 * time.Second},
+	{4, 8 * time.Second, 16 * time.Second},
+	{10, 30 * time.Second, time.Minute},
+	{1000, 30 * time.Second, time.Minute},
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for _, e := range backoffTests {
+		for i := 0; i < 100; i++ {
+			d := backoffDelay(e.attempts, 2*time.Second, time.Minute)
+			if d < e.min || d > e.max {
+				t.Errorf("attempt %d: delay %v out of bounds [%v, %v]", e.attempts, d, e.min, e.max)
+				break
+			}
+		}
+	}
+}
+
+func TestBackoffPick(t *testing.T) {
+	var b backoffState
+	servers := []string{"a", "b", "c"}
+	now := time.Now()
+	window := time.Minute
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, b.pick(servers, now, window))
+	}
+	if e := []string{"a", "b", "c", "a"}; !equalStrings(picked, e) {
+		t.Errorf("expected rotation %v, got %v", e, picked)
+	}
+
+	b.fail("b", "connection refused", now)
+	b.fail("c", "connection refused", now.Add(-time.Second))
+	if s := b.pick(servers, now, window); s != "a" {
+		t.Errorf("expected a, got %s", s)
+	}
+	if s := b.pick(servers, now, window); s != "a" {
+		t.Errorf("expected a again, as others failed recently, got %s", s)
+	}
+
+	b.fail("a", "connection refused", now)
+	if s := b.pick(servers, now, window); s != "c" {
+		t.Errorf("expected c, which failed the longest time ago, got %s", s)
+	}
+
+	if s := b.pick(servers, now.Add(window), window); s != "a" {
+		t.Errorf("expected rotation to resume after window, got %s", s)
+	}
+	if b.Attempts != 3 || b.LastError != "connection refused" {
+		t.Errorf("unexpected status: %+v", b.Status)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
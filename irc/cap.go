@@ -0,0 +1,263 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// capState keeps track of IRCv3 capability negotiation. Apart from enabled,
+// which is guarded by capLock as it's read by plugins, it's only touched from
+// Keeper (before Receiver is spawned) and Receiver.
+type capState struct {
+	negotiating bool              // registration is on hold until CAP END
+	wanted      map[string]bool   // capabilities we'd like to have enabled
+	listing     map[string]string // CAP LS reply being assembled
+	available   map[string]string // capabilities offered by the server
+	pending     map[string]bool   // requested, but not yet ACKed or NAKed
+	enabled     map[string]string // capabilities ACKed by the server
+}
+
+// startCapabilities starts capability negotiation if there are any
+// capabilities we'd like to use on this network. Returns false if there's
+// nothing to negotiate.
+func (c *Connection) startCapabilities() bool {
+	wanted := make(map[string]bool)
+	for _, capability := range c.cfg.LookupStringSlice(c.cfgContext(), "Capabilities") {
+		if capability != "" {
+			wanted[strings.ToLower(capability)] = true
+		}
+	}
+	if len(c.sasl.mechanisms) > 0 {
+		wanted["sasl"] = true
+	}
+
+	c.capLock.Lock()
+	c.caps = capState{
+		wanted:    wanted,
+		listing:   make(map[string]string),
+		available: make(map[string]string),
+		pending:   make(map[string]bool),
+		enabled:   make(map[string]string),
+	}
+	c.capLock.Unlock()
+
+	if len(wanted) == 0 {
+		return false
+	}
+
+	// cap-notify is implicitly enabled by CAP LS 302, but it doesn't hurt
+	// asking for it explicitly on servers that support it.
+	wanted["cap-notify"] = true
+	c.caps.negotiating = true
+	c.Sender(Message{
+		Command: "CAP",
+		Params:  []string{"LS", "302"},
+	})
+	return true
+}
+
+// handleCapabilities handles CAP subcommands, both during registration and
+// later, when servers notify us about capabilities that appeared or went
+// away.
+func (c *Connection) handleCapabilities(msg *Message) {
+	switch msg.Command {
+	case "CAP":
+	case "410":
+		// ERR_INVALIDCAPCMD, server doesn't understand the way we use CAP.
+		if c.caps.negotiating {
+			log.Println(c.network, "invalid capability command:", msg.Params)
+			c.capEnd()
+		}
+		return
+	case "421":
+		// ERR_UNKNOWNCOMMAND, server doesn't understand CAP at all.
+		if c.caps.negotiating && len(msg.Params) > 1 && strings.ToUpper(msg.Params[1]) == "CAP" {
+			log.Println(c.network, "server doesn't support capability negotiation")
+			c.capEnd()
+		}
+		return
+	case "001":
+		// Registered without us ending negotiation, which means the server
+		// doesn't know about capabilities at all.
+		c.caps.negotiating = false
+		return
+	default:
+		return
+	}
+
+	if len(msg.Params) < 2 {
+		return
+	}
+
+	switch strings.ToUpper(msg.Params[1]) {
+	case "LS":
+		for k, v := range parseCapabilities(msg.Trailing) {
+			c.caps.listing[k] = v
+		}
+		// Multiline replies have "*" before the final parameter on all but
+		// the last line.
+		if len(msg.Params) > 2 && msg.Params[2] == "*" {
+			return
+		}
+
+		c.caps.available = c.caps.listing
+		c.caps.listing = make(map[string]string)
+		log.Println(c.network, "server capabilities:", capabilityList(capabilityNames(c.caps.available)))
+
+		if _, ok := c.caps.available["sasl"]; c.caps.negotiating && c.caps.wanted["sasl"] && !ok {
+			c.saslFailed("server does not support SASL")
+			if !c.caps.negotiating {
+				return
+			}
+		}
+		c.requestCapabilities(c.caps.available)
+		c.capMaybeEnd()
+	case "NEW":
+		offered := parseCapabilities(msg.Trailing)
+		for k, v := range offered {
+			c.caps.available[k] = v
+		}
+		log.Println(c.network, "new capabilities:", capabilityList(capabilityNames(offered)))
+		c.requestCapabilities(offered)
+	case "DEL":
+		c.capLock.Lock()
+		for k := range parseCapabilities(msg.Trailing) {
+			delete(c.caps.available, k)
+			delete(c.caps.enabled, k)
+		}
+		c.capLock.Unlock()
+		log.Println(c.network, "capabilities removed:", msg.Trailing)
+	case "ACK":
+		c.capLock.Lock()
+		for _, capability := range strings.Fields(msg.Trailing) {
+			capability = strings.ToLower(capability)
+			if strings.HasPrefix(capability, "-") {
+				capability = capability[1:]
+				delete(c.caps.enabled, capability)
+			} else {
+				c.caps.enabled[capability] = c.caps.available[capability]
+			}
+			delete(c.caps.pending, capability)
+		}
+		c.capLock.Unlock()
+		log.Println(c.network, "capabilities enabled:", msg.Trailing)
+
+		if c.caps.negotiating && capListed(msg.Trailing, "sasl") && c.HasCapability("sasl") {
+			c.startSASL(c.caps.enabled["sasl"])
+		}
+		c.capMaybeEnd()
+	case "NAK":
+		for capability := range parseCapabilities(msg.Trailing) {
+			delete(c.caps.pending, capability)
+		}
+		log.Println(c.network, "capabilities refused:", msg.Trailing)
+
+		if c.caps.negotiating && capListed(msg.Trailing, "sasl") {
+			c.saslFailed("server refused sasl capability")
+		}
+		c.capMaybeEnd()
+	}
+}
+
+// requestCapabilities requests those of offered capabilities we want to use.
+func (c *Connection) requestCapabilities(offered map[string]string) {
+	var request []string
+
+	for capability := range offered {
+		if c.caps.wanted[capability] && !c.HasCapability(capability) && !c.caps.pending[capability] {
+			request = append(request, capability)
+			c.caps.pending[capability] = true
+		}
+	}
+
+	if len(request) == 0 {
+		return
+	}
+
+	c.Sender(Message{
+		Command:  "CAP",
+		Params:   []string{"REQ"},
+		Trailing: capabilityList(request),
+	})
+}
+
+// capMaybeEnd ends negotiation if there are no outstanding requests and SASL
+// authentication isn't in progress.
+func (c *Connection) capMaybeEnd() {
+	if !c.caps.negotiating || len(c.caps.pending) > 0 || c.sasl.active {
+		return
+	}
+	c.capEnd()
+}
+
+// capEnd ends capability negotiation, letting the server complete
+// registration.
+func (c *Connection) capEnd() {
+	c.caps.negotiating = false
+	c.Sender(Message{
+		Command: "CAP",
+		Params:  []string{"END"},
+	})
+}
+
+// HasCapability checks if capability has been enabled on this connection.
+func (c *Connection) HasCapability(capability string) bool {
+	c.capLock.RLock()
+	defer c.capLock.RUnlock()
+	_, ok := c.caps.enabled[strings.ToLower(capability)]
+	return ok
+}
+
+// Capabilities returns capabilities enabled on this connection, along with
+// their values, as advertised by the server.
+func (c *Connection) Capabilities() map[string]string {
+	c.capLock.RLock()
+	defer c.capLock.RUnlock()
+	r := make(map[string]string, len(c.caps.enabled))
+	for k, v := range c.caps.enabled {
+		r[k] = v
+	}
+	return r
+}
+
+// parseCapabilities parses a space separated list of capabilities, with
+// optional values, into a map.
+func parseCapabilities(list string) map[string]string {
+	r := make(map[string]string)
+	for _, capability := range strings.Fields(list) {
+		capability = strings.TrimLeft(capability, "-~=")
+		var value string
+		if i := strings.IndexByte(capability, '='); i >= 0 {
+			capability, value = capability[:i], capability[i+1:]
+		}
+		r[strings.ToLower(capability)] = value
+	}
+	return r
+}
+
+// capListed checks if capability is present in a space separated capability
+// list, ignoring capability values and modifiers.
+func capListed(list, capability string) bool {
+	_, ok := parseCapabilities(list)[strings.ToLower(capability)]
+	return ok
+}
+
+// capabilityNames returns names of capabilities from a parsed list.
+func capabilityNames(capabilities map[string]string) []string {
+	var r []string
+	for k := range capabilities {
+		r = append(r, k)
+	}
+	return r
+}
+
+// capabilityList turns capability names into a sorted, space separated list.
+func capabilityList(capabilities []string) string {
+	sort.Strings(capabilities)
+	return strings.Join(capabilities, " ")
+}
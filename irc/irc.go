@@ -6,18 +6,27 @@ package irc
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"log"
 	"math/rand"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/arachnist/dyncfg"
 )
 
+var errNoServers = errors.New("no servers configured")
+
 const delim byte = '\n'
 const endline string = "\r\n"
 
+// How long Quit waits for QUIT message to be sent, if "QuitTimeout" is not
+// configured.
+const defaultQuitTimeout = 5
+
 // Connection struct. Contains basic information about this connection, and quit
 // channels.
 type Connection struct {
@@ -28,31 +37,87 @@ type Connection struct {
 	conn             net.Conn
 	reconnect        chan struct{}
 	reconnectCleanup chan struct{}
-	Quit             chan struct{}
+	quit             chan struct{}
+	quitOnce         sync.Once
+	closed           bool
 	quitrecv         chan struct{}
 	quitkeeper       chan struct{}
 	l                sync.Mutex
 	cfg              *dyncfg.Dyncfg
+	caps             capState
+	capLock          sync.RWMutex
+	sasl             saslState
+	queue            *sendQueue
+	transmitterDone  chan struct{}
+	account          string
+	accountLock      sync.RWMutex
+	self             Prefix
+	nick             nickState
+	selfLock         sync.RWMutex
+	isupport         ISupport
+	isupportLock     sync.RWMutex
+	state            stateTracker
+	sent             func(Message)
+	backoff          backoffState
+	keepalive        keepaliveState
+	statusLock       sync.RWMutex
 }
 
-// Sender sends IRC messages to server and logs their contents.
+// Sender queues IRC messages to be sent to server by Transmitter. PRIVMSG and
+// NOTICE messages too long to fit in a single line are split into several
+// messages, up to "MaxLines" (unlimited if not configured).
 func (c *Connection) Sender(msg Message) {
+	maxLines := c.cfg.LookupInt(c.cfgContext(), "MaxLines")
+	for _, m := range splitMessage(msg, c.selfPrefix(), c.maxLength(), maxLines) {
+		c.queue.push(m)
+	}
+}
+
+// write sends IRC message to server and logs its contents.
+func (c *Connection) write(msg Message) {
 	c.l.Lock()
-	defer c.l.Unlock()
 	c.writer.WriteString(msg.String() + endline)
 	log.Println(c.network, "-->", msg.String())
 	c.writer.Flush()
-	if msg.WireLen() > maxLength {
-		if msg.Command == "PRIVMSG" { // we don't care otherwise
-			newMsg := msg
-			newMsg.Trailing = "Message truncated"
-			go c.Sender(newMsg)
+	c.l.Unlock()
+
+	if c.sent != nil {
+		self := c.selfPrefix()
+		msg.Prefix = &self
+		msg.Context = map[string]string{
+			"Network": c.network,
+			"Source":  self.Name,
+		}
+		if len(msg.Params) > 0 {
+			msg.Context["Target"] = msg.Params[0]
 		}
+		c.sent(msg)
+	}
+}
+
+// OnSent sets a function called with every message sent to the server, with
+// our prefix, and context set like for received messages. Has to be called
+// before Setup.
+func (c *Connection) OnSent(f func(Message)) {
+	c.sent = f
+}
+
+// stopTransmitter stops Transmitter, Regainer and Pinger for current
+// connection, and drops messages which didn't make it out. Has to be called with c.l held.
+func (c *Connection) stopTransmitter() {
+	if c.transmitterDone != nil {
+		close(c.transmitterDone)
+		c.transmitterDone = nil
+	}
+	if n := c.queue.reset(); n > 0 {
+		log.Println(c.network, "dropped", n, "queued messages")
 	}
 }
 
 // Receiver receives IRC messages from server, logs their contents, sets message
 // context and initializes disconnect procedure on timeout or other errors.
+// For QUIT and NICK, context also has "Channels": comma separated channels
+// we shared with the user.
 func (c *Connection) Receiver() {
 	log.Println(c.network, "spawned Receiver")
 	for {
@@ -79,6 +144,15 @@ func (c *Connection) Receiver() {
 
 		log.Println(c.network, "<--", msg.String())
 
+		// QUIT and NICK don't name channels, and once they're handled,
+		// we no longer know which channels the user was on.
+		var channels []string
+		if (msg.Command == "QUIT" || msg.Command == "NICK") && msg.Prefix != nil {
+			channels = c.UserChannels(msg.Prefix.Name)
+		}
+
+		c.handle(msg)
+
 		if msg.Params == nil {
 			tgt = ""
 		} else {
@@ -94,6 +168,9 @@ func (c *Connection) Receiver() {
 			"Source":  src,
 			"Target":  tgt,
 		}
+		if len(channels) > 0 {
+			msg.Context["Channels"] = strings.Join(channels, ",")
+		}
 
 		c.dispatcher(c.Sender, *msg)
 		select {
@@ -105,18 +182,41 @@ func (c *Connection) Receiver() {
 	}
 }
 
+// handle takes care of messages that are part of connection housekeeping,
+// before they are passed on to dispatcher.
+func (c *Connection) handle(msg *Message) {
+	c.handleKeepalive(msg)
+	c.handleISupport(msg)
+	c.handleSelf(msg)
+	c.handleNick(msg)
+	c.handleState(msg)
+	c.handleCapabilities(msg)
+	c.handleSASL(msg)
+}
+
 // Cleaner cleans up coroutines on IRC connection errors and initializes
 // reconnection.
 func (c *Connection) Cleaner() {
 	log.Println(c.network, "spawned Cleaner")
 	for {
 		select {
-		case <-c.Quit:
+		case <-c.quit:
 			log.Println(c.network, "closing connection")
 			c.l.Lock()
 			defer c.l.Unlock()
 			log.Println(c.network, "cleaning up!")
-			c.quitrecv <- struct{}{}
+			c.closed = true
+			unregister(c)
+			// Keeper might be waiting for a reconnect request that's never
+			// coming.
+			select {
+			case c.quitkeeper <- struct{}{}:
+			default:
+			}
+			c.stopTransmitter()
+			if c.quitrecv != nil {
+				c.quitrecv <- struct{}{}
+			}
 			// there's a slight chance to hit this if quit request is received
 			// before irc connection is established, possibly between reconnects
 			if c.conn != nil {
@@ -128,17 +228,20 @@ func (c *Connection) Cleaner() {
 			log.Println(c.network, "cleaning up before reconnect!")
 			c.l.Lock()
 			log.Println(c.network, "cleaning up!")
+			c.stopTransmitter()
 			c.quitrecv <- struct{}{}
 			c.conn.Close()
 			log.Println(c.network, "sending reconnect signal!")
 			c.l.Unlock()
+			c.disconnected()
 			c.reconnect <- struct{}{}
 		}
 	}
 }
 
 // Keeper makes sure that IRC connection is alive by reconnecting when
-// requested and restarting Receiver goroutine.
+// requested and restarting Receiver goroutine. Servers are tried in turns,
+// with exponential backoff between failed attempts.
 func (c *Connection) Keeper() {
 	log.Println(c.network, "spawned Keeper")
 	context := make(map[string]string)
@@ -146,45 +249,123 @@ func (c *Connection) Keeper() {
 	for {
 		select {
 		case <-c.quitkeeper:
+			c.l.Lock()
 			if c.quitrecv != nil {
 				close(c.quitrecv)
 			}
+			c.l.Unlock()
 			return
 		case <-c.reconnect:
 		}
 
+		if d := c.reconnectDelay(); d > 0 {
+			log.Println(c.network, "reconnecting in", d)
+			time.Sleep(d)
+		}
+
 		c.l.Lock()
+		if c.closed {
+			log.Println(c.network, "connection closed, not reconnecting")
+			c.l.Unlock()
+			return
+		}
 		if c.quitrecv != nil {
 			close(c.quitrecv)
 		}
 		c.quitrecv = make(chan struct{}, 1)
 		servers := c.cfg.LookupStringSlice(context, "Servers")
+		if len(servers) == 0 {
+			c.l.Unlock()
+			log.Println(c.network, "no servers configured")
+			c.dialFailed("", errNoServers)
+			c.reconnect <- struct{}{}
+			continue
+		}
 
-		server := servers[rand.Intn(len(servers))]
+		server := c.pickServer(servers)
 		log.Println(c.network, "connecting to", server)
 		err := c.Dial(server)
+		if err == nil {
+			c.connected(server)
+			// Anything queued before we got connected is stale now.
+			c.stopTransmitter()
+			c.transmitterDone = make(chan struct{})
+			go c.Transmitter(c.transmitterDone)
+			go c.Regainer(c.transmitterDone)
+			go c.Pinger(c.transmitterDone)
+		}
 		c.l.Unlock()
 		if err == nil {
-			go c.Receiver()
-
 			log.Println(c.network, "Initializing IRC connection")
-			c.Sender(Message{
-				Command:  "NICK",
-				Trailing: c.cfg.LookupString(context, "Nick"),
-			})
-			c.Sender(Message{
-				Command:  "USER",
-				Params:   []string{c.cfg.LookupString(context, "User"), "0", "*"},
-				Trailing: c.cfg.LookupString(context, "RealName"),
-			})
+			c.startRegistration()
+
+			go c.Receiver()
 		} else {
 			log.Println(c.network, "connection error", err.Error())
-			time.Sleep(time.Second * 3)
+			c.dialFailed(server, err)
 			c.reconnect <- struct{}{}
 		}
 	}
 }
 
+// Quit sends QUIT with message to the server, and closes the connection for
+// good. If we're connected, it waits up to "QuitTimeout" seconds for the QUIT
+// message to be sent.
+func (c *Connection) Quit(message string) {
+	c.quitOnce.Do(func() {
+		c.l.Lock()
+		connected := c.transmitterDone != nil
+		c.l.Unlock()
+
+		if connected {
+			c.Sender(Message{
+				Command:  "QUIT",
+				Trailing: message,
+			})
+			c.waitQuitSent()
+		}
+
+		c.quit <- struct{}{}
+	})
+}
+
+// waitQuitSent waits up to "QuitTimeout" seconds for QUIT to be written to
+// the server.
+func (c *Connection) waitQuitSent() {
+	timeout := c.lookupFloat("QuitTimeout", defaultQuitTimeout)
+	deadline := time.Now().Add(time.Duration(timeout * float64(time.Second)))
+	for c.queue.urgentPending() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Reconnect sends QUIT with message to the server, and drops the connection,
+// so Keeper connects again, to the next server on the list. It's a no-op if
+// we're not connected.
+func (c *Connection) Reconnect(message string) {
+	c.l.Lock()
+	done := c.transmitterDone
+	closed := c.closed
+	c.l.Unlock()
+	if done == nil || closed {
+		return
+	}
+
+	c.reconnectRequested()
+	c.Sender(Message{
+		Command:  "QUIT",
+		Trailing: message,
+	})
+	c.waitQuitSent()
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	// Don't close a connection made in the meantime.
+	if c.transmitterDone == done {
+		c.conn.Close()
+	}
+}
+
 // Setup performs initialization tasks.
 func (c *Connection) Setup(dispatcher func(func(Message), Message), network string, config *dyncfg.Dyncfg) {
 	rand.Seed(time.Now().UnixNano())
@@ -192,24 +373,49 @@ func (c *Connection) Setup(dispatcher func(func(Message), Message), network stri
 	c.reconnect = make(chan struct{}, 1)
 	c.reconnectCleanup = make(chan struct{}, 1)
 	c.quitkeeper = make(chan struct{}, 1)
-	c.Quit = make(chan struct{}, 1)
+	c.quit = make(chan struct{}, 1)
+	c.queue = newSendQueue()
+	c.isupport = DefaultISupport()
+	c.state.reset()
 	c.network = network
 	c.dispatcher = dispatcher
 	c.cfg = config
 
+	register(c)
+
 	c.reconnect <- struct{}{}
 	go c.Keeper()
 	go c.Cleaner()
 	return
 }
 
-// Dial connects to irc server and sets up bufio reader and writer.
+// Dial connects to irc server and sets up bufio reader and writer. If "TLS" is
+// enabled for this network, TLS handshake is performed before returning.
 func (c *Connection) Dial(server string) error {
 	conn, err := net.DialTimeout("tcp", server, time.Second*30)
 	if err != nil {
 		log.Println(c.network, "Cannot connect to", server, "error:", err.Error())
 		return err
 	}
+
+	if c.lookupBool("TLS") {
+		config, err := c.tlsConfig(server)
+		if err != nil {
+			log.Println(c.network, "Cannot set up TLS for", server, "error:", err.Error())
+			conn.Close()
+			return err
+		}
+
+		tlsConn := tls.Client(conn, config)
+		tlsConn.SetDeadline(time.Now().Add(time.Second * 30))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Println(c.network, "TLS handshake with", server, "failed, error:", err.Error())
+			conn.Close()
+			return err
+		}
+		log.Println(c.network, "TLS handshake with", server, "completed")
+		conn = tlsConn
+	}
 	log.Println(c.network, "Connected to", server)
 	c.writer = bufio.NewWriter(conn)
 	c.reader = bufio.NewReader(conn)
@@ -8,11 +8,25 @@ package irc
 
 import (
 	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net"
 	"os"
+	"path"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -42,11 +56,7 @@ var input = []Message{
 var actualOutput []Message
 var actualInput []Message
 
-func fakeServer(t *testing.T) {
-	ln, err := net.Listen("tcp", ":36667")
-	if err != nil {
-		t.Error("fakeServer can't start listening")
-	}
+func fakeServer(t *testing.T, ln net.Listener) {
 	// twice, to test reconnects
 	for range []int{0, 2} {
 		var wg sync.WaitGroup
@@ -102,14 +112,25 @@ func fakeServer(t *testing.T) {
 var setupMutex sync.Mutex
 
 func TestSetup(t *testing.T) {
-	go fakeServer(t)
+	ln, err := net.Listen("tcp", ":36667")
+	if err != nil {
+		t.Fatal("fakeServer can't start listening")
+	}
+	defer ln.Close()
+
+	setupMutex.Lock()
+	actualOutput = nil
+	actualInput = nil
+	setupMutex.Unlock()
+
+	go fakeServer(t, ln)
 
 	var conn Connection
 	conn.Setup(fakeDispatcher, "TestNet", dyncfg.New(configLookupHelper))
 
 	time.Sleep(2 * time.Second)
 
-	conn.Quit <- struct{}{}
+	conn.Quit("")
 
 	// since we tested a reconnect, we should expect actual results to be
 	// multipled
@@ -135,6 +156,855 @@ func TestSetup(t *testing.T) {
 	}
 }
 
+// testCertificate generates a self-signed certificate valid for "irc.test",
+// and writes it out as a PEM file usable as TLSCAFile.
+func testCertificate(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("can't generate key:", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "irc.test"},
+		DNSNames:              []string{"irc.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("can't create certificate:", err)
+	}
+
+	caFile := path.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal("can't write CA file:", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+// testConfig writes values to a temporary configuration file, which takes
+// precedence over .testconfig.json.
+func testConfig(t *testing.T, dir string, values map[string]interface{}) *dyncfg.Dyncfg {
+	b, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal("can't encode test configuration:", err)
+	}
+
+	file := path.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal("can't write test configuration:", err)
+	}
+
+	return dyncfg.New(func(map[string]string) []string {
+		return []string{file, ".testconfig.json"}
+	})
+}
+
+func TestTLSSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, caFile := testCertificate(t, dir)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:36668", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal("fakeServer can't start listening")
+	}
+	defer ln.Close()
+
+	setupMutex.Lock()
+	actualOutput = nil
+	actualInput = nil
+	setupMutex.Unlock()
+
+	go fakeServer(t, ln)
+
+	var conn Connection
+	conn.Setup(fakeDispatcher, "TestTLSNet", testConfig(t, dir, map[string]interface{}{
+		"Servers":       []string{"127.0.0.1:36668"},
+		"TLS":           true,
+		"TLSCAFile":     caFile,
+		"TLSServerName": "irc.test",
+	}))
+
+	time.Sleep(2 * time.Second)
+
+	conn.Quit("")
+
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+	actualExpectedOutput := append(expectedOutput, expectedOutput...)
+	actualExpectedInput := append(input, input...)
+
+	if fmt.Sprintf("%+v", actualExpectedOutput) != fmt.Sprintf("%+v", actualOutput) {
+		t.Log("Expected output does not match actual output")
+		t.Logf("expected: %+v\n", actualExpectedOutput)
+		t.Logf("actual  : %+v\n", actualOutput)
+		t.Fail()
+	}
+
+	if fmt.Sprintf("%+v", actualExpectedInput) != fmt.Sprintf("%+v", actualInput) {
+		t.Log("Expected input does not match actual input")
+		t.Logf("expected: %+v\n", actualExpectedInput)
+		t.Logf("actual  : %+v\n", actualInput)
+		t.Fail()
+	}
+}
+
+var tlsPinningTests = []struct {
+	desc    string
+	pin     func(der []byte) string
+	caFile  bool
+	success bool
+}{
+	{
+		desc: "matching pin",
+		pin: func(der []byte) string {
+			sum := sha256.Sum256(der)
+			return hex.EncodeToString(sum[:])
+		},
+		success: true,
+	},
+	{
+		desc: "matching pin with colons and CA verification",
+		pin: func(der []byte) string {
+			sum := sha256.Sum256(der)
+			var r string
+			for i, b := range sum {
+				if i > 0 {
+					r += ":"
+				}
+				r += fmt.Sprintf("%02X", b)
+			}
+			return r
+		},
+		caFile:  true,
+		success: true,
+	},
+	{
+		desc: "mismatched pin",
+		pin: func([]byte) string {
+			return "00"
+		},
+		success: false,
+	},
+}
+
+func TestTLSPinning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, caFile := testCertificate(t, dir)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:36669", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal("fakeServer can't start listening")
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	for _, e := range tlsPinningTests {
+		t.Log("Running test", e.desc)
+
+		values := map[string]interface{}{
+			"TLS":             true,
+			"TLSServerName":   "irc.test",
+			"TLSFingerprints": []string{e.pin(cert.Certificate[0])},
+		}
+		if e.caFile {
+			values["TLSCAFile"] = caFile
+		}
+
+		conn := Connection{
+			network: "TestTLSNet",
+			cfg:     testConfig(t, dir, values),
+		}
+
+		err := conn.Dial("127.0.0.1:36669")
+		if (err == nil) != e.success {
+			t.Logf("expected success: %v, got error: %v", e.success, err)
+			t.Fail()
+		}
+		if err == nil {
+			conn.conn.Close()
+		}
+	}
+}
+
+// scriptedServer accepts a single connection, passes every message received
+// from the client to received, and writes back whatever respond returns.
+func scriptedServer(t *testing.T, ln net.Listener, respond func(Message) []string, received chan<- Message) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error("error accepting connection")
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		raw, err := reader.ReadString(delim)
+		if err != nil {
+			return
+		}
+
+		msg, err := ParseMessage(raw)
+		if err != nil {
+			t.Log("Failed parsing message from client:", raw)
+			t.Fail()
+			return
+		}
+		received <- *msg
+
+		for _, r := range respond(*msg) {
+			writer.WriteString(r + endline)
+		}
+		writer.Flush()
+	}
+}
+
+// expectMessages checks if messages coming from received match expected ones.
+func expectMessages(t *testing.T, received <-chan Message, expected []string) {
+	for _, e := range expected {
+		select {
+		case msg := <-received:
+			if msg.String() != e {
+				t.Logf("expected: %s", e)
+				t.Logf("actual  : %s", msg.String())
+				t.Fail()
+			}
+		case <-time.After(2 * time.Second):
+			t.Log("timed out waiting for", e)
+			t.Fail()
+			return
+		}
+	}
+}
+
+var saslTests = []struct {
+	desc     string
+	config   map[string]interface{}
+	respond  func(Message) []string
+	expected []string
+}{
+	{
+		desc: "plain",
+		config: map[string]interface{}{
+			"SASLMechanisms": []string{"PLAIN"},
+			"SASLUser":       "repost",
+			"SASLPassword":   "secret",
+		},
+		respond: func(m Message) []string {
+			switch m.String() {
+			case "CAP LS 302":
+				return []string{":irc.test CAP * LS :multi-prefix sasl"}
+			case "CAP REQ :sasl":
+				return []string{":irc.test CAP * ACK :sasl"}
+			case "AUTHENTICATE PLAIN":
+				return []string{"AUTHENTICATE +"}
+			case "AUTHENTICATE cmVwb3N0AHJlcG9zdABzZWNyZXQ=":
+				return []string{":irc.test 900 gorepost gorepost!repost@host repost :You are now logged in as repost", ":irc.test 903 gorepost :SASL authentication successful"}
+			}
+			return nil
+		},
+		expected: []string{
+			"CAP LS 302",
+			"NICK :gorepost",
+			"USER repost 0 * :https://github.com/arachnist/gorepost",
+			"CAP REQ :sasl",
+			"AUTHENTICATE PLAIN",
+			"AUTHENTICATE cmVwb3N0AHJlcG9zdABzZWNyZXQ=",
+			"CAP END",
+		},
+	},
+	{
+		desc: "external falling back to plain, then giving up",
+		config: map[string]interface{}{
+			"SASLMechanisms": []string{"EXTERNAL", "PLAIN"},
+			"SASLPassword":   "secret",
+		},
+		respond: func(m Message) []string {
+			switch m.String() {
+			case "CAP LS 302":
+				return []string{":irc.test CAP * LS :sasl=PLAIN,EXTERNAL"}
+			case "CAP REQ :sasl":
+				return []string{":irc.test CAP * ACK :sasl"}
+			case "AUTHENTICATE PLAIN", "AUTHENTICATE EXTERNAL":
+				return []string{"AUTHENTICATE +"}
+			case "AUTHENTICATE +", "AUTHENTICATE Z29yZXBvc3QAZ29yZXBvc3QAc2VjcmV0":
+				// 908 without parameters shouldn't trip us up.
+				return []string{":irc.test 908", ":irc.test 904 gorepost :SASL authentication failed"}
+			}
+			return nil
+		},
+		expected: []string{
+			"CAP LS 302",
+			"NICK :gorepost",
+			"USER repost 0 * :https://github.com/arachnist/gorepost",
+			"CAP REQ :sasl",
+			"AUTHENTICATE EXTERNAL",
+			"AUTHENTICATE +",
+			"AUTHENTICATE PLAIN",
+			"AUTHENTICATE Z29yZXBvc3QAZ29yZXBvc3QAc2VjcmV0",
+			"CAP END",
+		},
+	},
+	{
+		desc: "no sasl on server",
+		config: map[string]interface{}{
+			"SASLMechanisms": []string{"PLAIN"},
+		},
+		respond: func(m Message) []string {
+			if m.String() == "CAP LS 302" {
+				return []string{":irc.test CAP * LS :multi-prefix"}
+			}
+			return nil
+		},
+		expected: []string{
+			"CAP LS 302",
+			"NICK :gorepost",
+			"USER repost 0 * :https://github.com/arachnist/gorepost",
+			"CAP END",
+		},
+	},
+}
+
+func TestSASL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-sasl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, e := range saslTests {
+		t.Log("Running test", e.desc)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("scriptedServer can't start listening")
+		}
+
+		received := make(chan Message, 16)
+		go scriptedServer(t, ln, e.respond, received)
+
+		e.config["Servers"] = []string{ln.Addr().String()}
+
+		var conn Connection
+		conn.Setup(func(func(Message), Message) {}, "TestSASLNet", testConfig(t, dir, e.config))
+
+		expectMessages(t, received, e.expected)
+
+		conn.Quit("")
+		ln.Close()
+	}
+}
+
+func TestAccountNotify(t *testing.T) {
+	var c Connection
+	c.isupport = DefaultISupport()
+	c.self = Prefix{Name: "gorepost"}
+
+	for _, e := range []struct {
+		raw     string
+		account string
+	}{
+		{":Gorepost!repost@h ACCOUNT repost", "repost"},
+		{":other!u@h ACCOUNT other", "repost"},
+		{":gorepost!repost@h ACCOUNT *", ""},
+		{":irc.test 900 gorepost gorepost!repost@h repost :You are now logged in as repost", "repost"},
+	} {
+		msg, _ := ParseMessage(e.raw)
+		c.handleSASL(msg)
+		if c.Account() != e.account {
+			t.Errorf("%s: expected account %q, got %q", e.raw, e.account, c.Account())
+		}
+	}
+}
+
+var capDispatched = make(chan Message, 16)
+
+func TestCapabilities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-cap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	received := make(chan Message, 16)
+	notify := make(chan string, 1)
+	go scriptedServer(t, ln, func(m Message) []string {
+		switch m.String() {
+		case "CAP LS 302":
+			return []string{
+				":irc.test CAP * LS * :multi-prefix cap-notify",
+				":irc.test CAP * LS :away-notify account-notify=foo",
+			}
+		case "CAP REQ :account-notify cap-notify multi-prefix":
+			return []string{":irc.test CAP * ACK :account-notify cap-notify multi-prefix"}
+		case "CAP END":
+			return []string{":irc.test 001 gorepost :Welcome", <-notify}
+		case "CAP REQ :extended-join":
+			return []string{":irc.test CAP gorepost ACK :extended-join"}
+		}
+		return nil
+	}, received)
+
+	var conn Connection
+	conn.Setup(func(output func(Message), msg Message) {
+		if msg.Command == "CAP" {
+			capDispatched <- msg
+		}
+	}, "TestCapNet", testConfig(t, dir, map[string]interface{}{
+		"Servers":      []string{ln.Addr().String()},
+		"Capabilities": []string{"multi-prefix", "account-notify", "extended-join"},
+	}))
+	defer func() { conn.Quit("") }()
+
+	if GetConnection("TestCapNet") != &conn {
+		t.Error("connection not registered")
+	}
+
+	notify <- ":irc.test CAP gorepost NEW :extended-join"
+	expectMessages(t, received, []string{
+		"CAP LS 302",
+		"NICK :gorepost",
+		"USER repost 0 * :https://github.com/arachnist/gorepost",
+		"CAP REQ :account-notify cap-notify multi-prefix",
+		"CAP END",
+		"CAP REQ :extended-join",
+	})
+
+	// CAP messages are passed on to plugins after being handled, so once we
+	// see the last ACK, it has been processed.
+	for done := false; !done; {
+		select {
+		case m := <-capDispatched:
+			done = m.Params[1] == "ACK" && m.Trailing == "extended-join"
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for CAP messages to be dispatched")
+		}
+	}
+
+	expected := map[string]string{
+		"account-notify": "foo",
+		"cap-notify":     "",
+		"extended-join":  "",
+		"multi-prefix":   "",
+	}
+	if fmt.Sprintf("%v", conn.Capabilities()) != fmt.Sprintf("%v", expected) {
+		t.Logf("expected: %v", expected)
+		t.Logf("actual  : %v", conn.Capabilities())
+		t.Fail()
+	}
+	if conn.HasCapability("away-notify") {
+		t.Error("away-notify enabled, even though it was never requested")
+	}
+}
+
+var nickTests = []struct {
+	desc     string
+	config   map[string]interface{}
+	respond  func(Message) []string
+	expected []string
+}{
+	{
+		desc: "alternative nicks, regain using monitor",
+		config: map[string]interface{}{
+			"AltNicks": []string{"gorepost2"},
+		},
+		respond: func(m Message) []string {
+			switch m.String() {
+			case "NICK :gorepost":
+				return []string{":irc.test 433 * gorepost :Nickname is already in use"}
+			case "NICK :gorepost2":
+				return []string{":irc.test 433 * gorepost2 :Nickname is already in use"}
+			case "NICK :gorepost_":
+				return []string{
+					":irc.test 001 gorepost_ :Welcome gorepost_!repost@example.com",
+					":irc.test 005 gorepost_ CHANTYPES=# MONITOR=100 :are supported by this server",
+					":irc.test 422 gorepost_ :MOTD File is missing",
+				}
+			case "MONITOR + gorepost":
+				return []string{":irc.test 731 gorepost_ :gorepost"}
+			}
+			return nil
+		},
+		expected: []string{
+			"NICK :gorepost",
+			"USER repost 0 * :https://github.com/arachnist/gorepost",
+			"NICK :gorepost2",
+			"NICK :gorepost_",
+			"MONITOR + gorepost",
+			"NICK :gorepost",
+		},
+	},
+	{
+		desc: "suffix, regain using ison",
+		config: map[string]interface{}{
+			"NickRegainInterval": 0.1,
+		},
+		respond: func(m Message) []string {
+			switch m.String() {
+			case "NICK :gorepost":
+				return []string{":irc.test 433 * gorepost :Nickname is already in use"}
+			case "NICK :gorepost_":
+				return []string{":irc.test 001 gorepost_ :Welcome"}
+			case "ISON :gorepost":
+				return []string{":irc.test 303 gorepost_ :"}
+			}
+			return nil
+		},
+		expected: []string{
+			"NICK :gorepost",
+			"USER repost 0 * :https://github.com/arachnist/gorepost",
+			"NICK :gorepost_",
+			"ISON :gorepost",
+			"NICK :gorepost",
+		},
+	},
+}
+
+func TestNickCollision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-nick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, e := range nickTests {
+		t.Log("Running test", e.desc)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("scriptedServer can't start listening")
+		}
+
+		received := make(chan Message, 16)
+		go scriptedServer(t, ln, e.respond, received)
+
+		e.config["Servers"] = []string{ln.Addr().String()}
+
+		var conn Connection
+		conn.Setup(func(func(Message), Message) {}, "TestNickNet", testConfig(t, dir, e.config))
+
+		expectMessages(t, received, e.expected)
+		if nick := conn.Nick(); nick != "gorepost_" {
+			t.Errorf("expected current nick gorepost_, got %s", nick)
+		}
+
+		conn.Quit("")
+		ln.Close()
+	}
+}
+
+func TestQuit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-quit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	received := make(chan Message, 16)
+	go scriptedServer(t, ln, func(m Message) []string {
+		if m.Command == "USER" {
+			return []string{":irc.test 001 gorepost :Welcome"}
+		}
+		return nil
+	}, received)
+
+	var conn Connection
+	conn.Setup(func(func(Message), Message) {}, "TestQuitNet", testConfig(t, dir, map[string]interface{}{
+		"Servers": []string{ln.Addr().String()},
+	}))
+
+	expectMessages(t, received, []string{
+		"NICK :gorepost",
+		"USER repost 0 * :https://github.com/arachnist/gorepost",
+	})
+
+	conn.Quit("bye")
+	expectMessages(t, received, []string{"QUIT :bye"})
+
+	// Quitting twice shouldn't block.
+	conn.Quit("bye")
+
+	keeper := fmt.Sprintf("(*Connection).Keeper(%p", &conn)
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		buf := make([]byte, 1<<20)
+		if !strings.Contains(string(buf[:runtime.Stack(buf, true)]), keeper) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Keeper still running after Quit")
+		}
+	}
+}
+
+func TestMessageContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	received := make(chan Message, 16)
+	go scriptedServer(t, ln, func(m Message) []string {
+		if m.Command == "USER" {
+			return []string{
+				":irc.test 001 gorepost :Welcome",
+				":gorepost!repost@h JOIN #chan",
+				":other!u@h JOIN #chan",
+				":other!u@h NICK renamed",
+				":renamed!u@h QUIT :bye",
+			}
+		}
+		return nil
+	}, received)
+
+	dispatched := make(chan Message, 16)
+	sent := make(chan Message, 16)
+	var conn Connection
+	conn.OnSent(func(m Message) {
+		if m.Command == "PRIVMSG" {
+			sent <- m
+		}
+	})
+	conn.Setup(func(output func(Message), m Message) {
+		if m.Command == "NICK" || m.Command == "QUIT" {
+			dispatched <- m
+		}
+	}, "TestContextNet", testConfig(t, dir, map[string]interface{}{
+		"Servers": []string{ln.Addr().String()},
+	}))
+	defer conn.Quit("")
+
+	for _, e := range []string{"NICK", "QUIT"} {
+		select {
+		case m := <-dispatched:
+			if m.Command != e || m.Context["Channels"] != "#chan" {
+				t.Errorf("expected %s with channels #chan, got %+v", e, m)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for", e)
+		}
+	}
+
+	conn.Sender(Message{Command: "PRIVMSG", Params: []string{"#chan"}, Trailing: "hi"})
+	select {
+	case m := <-sent:
+		if m.Prefix == nil || m.Prefix.Name != "gorepost" || m.Context["Network"] != "TestContextNet" || m.Context["Target"] != "#chan" {
+			t.Errorf("unexpected sent message: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for sent message")
+	}
+}
+
+func TestJoinModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	received := make(chan Message, 16)
+	go scriptedServer(t, ln, func(m Message) []string {
+		switch m.Command {
+		case "USER":
+			return []string{
+				":irc.test 001 gorepost :Welcome",
+				":gorepost!repost@h JOIN #chan",
+			}
+		case "MODE":
+			return []string{":irc.test 324 gorepost #chan +ntl 10"}
+		}
+		return nil
+	}, received)
+
+	var conn Connection
+	conn.Setup(func(func(Message), Message) {}, "TestModesNet", testConfig(t, dir, map[string]interface{}{
+		"Servers": []string{ln.Addr().String()},
+	}))
+	defer conn.Quit("")
+
+	expectMessages(t, received, []string{
+		"NICK :gorepost",
+		"USER repost 0 * :https://github.com/arachnist/gorepost",
+		"WHO #chan",
+		"MODE #chan",
+	})
+
+	expected := map[string]string{"n": "", "t": "", "l": "10"}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ch, ok := conn.Channel("#chan")
+		if ok && fmt.Sprint(ch.Modes) == fmt.Sprint(expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected modes %v, got %v", expected, ch.Modes)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	received := make(chan Message, 16)
+	go scriptedServer(t, ln, func(m Message) []string {
+		if m.Command == "USER" {
+			return []string{":irc.test 001 gorepost :Welcome"}
+		}
+		return nil
+	}, received)
+
+	var conn Connection
+	conn.Setup(func(func(Message), Message) {}, "TestReconnectNet", testConfig(t, dir, map[string]interface{}{
+		"Servers": []string{ln.Addr().String()},
+	}))
+	defer conn.Quit("")
+
+	expectMessages(t, received, []string{
+		"NICK :gorepost",
+		"USER repost 0 * :https://github.com/arachnist/gorepost",
+	})
+
+	reconnected := make(chan Message, 16)
+	go scriptedServer(t, ln, func(Message) []string { return nil }, reconnected)
+
+	conn.Reconnect("brb")
+	expectMessages(t, received, []string{"QUIT :brb"})
+	expectMessages(t, reconnected, []string{"NICK :gorepost"})
+
+	if s := conn.Status(); s.Attempts != 0 {
+		t.Errorf("requested reconnect shouldn't count as a failed attempt: %+v", s)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorepost-keepalive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("scriptedServer can't start listening")
+	}
+	defer ln.Close()
+
+	var pongs sync.Mutex
+	answer := true
+	received := make(chan Message, 16)
+	go scriptedServer(t, ln, func(m Message) []string {
+		switch m.Command {
+		case "USER":
+			return []string{":irc.test 001 gorepost :Welcome"}
+		case "PING":
+			pongs.Lock()
+			defer pongs.Unlock()
+			if answer {
+				return []string{":irc.test PONG irc.test :" + m.Trailing}
+			}
+		}
+		return nil
+	}, received)
+
+	var conn Connection
+	conn.Setup(func(func(Message), Message) {}, "TestKeepaliveNet", testConfig(t, dir, map[string]interface{}{
+		"Servers":      []string{ln.Addr().String()},
+		"PingInterval": 0.1,
+		"PingTimeout":  0.3,
+	}))
+	defer conn.Quit("")
+
+	// Wait for a couple of PINGs, so we know the first one got a reply.
+	for pings := 0; pings < 2; {
+		select {
+		case m := <-received:
+			if m.Command == "PING" {
+				pings++
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for PING")
+		}
+	}
+	if lag := conn.Lag(); lag <= 0 || lag > time.Second {
+		t.Errorf("unexpected lag: %v", lag)
+	}
+
+	// Without replies, connection should be dropped, and reestablished.
+	pongs.Lock()
+	answer = false
+	pongs.Unlock()
+
+	reconnected := make(chan Message, 16)
+	go scriptedServer(t, ln, func(Message) []string { return nil }, reconnected)
+	expectMessages(t, reconnected, []string{"NICK :gorepost"})
+
+	if s := conn.Status(); s.Attempts != 1 {
+		t.Errorf("short-lived connection should count as a failed attempt: %+v", s)
+	}
+}
+
 func fakeDispatcher(output func(Message), input Message) {
 	// nullify Context as it isn't transmitted over the wire
 	setupMutex.Lock()
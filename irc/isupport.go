@@ -0,0 +1,276 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ISupport describes features advertised by the server in RPL_ISUPPORT (005)
+// messages. Fields hold defaults, as assumed by RFC1459, until the server
+// tells us otherwise.
+type ISupport struct {
+	ChanTypes     string            // channel name prefixes
+	PrefixModes   string            // channel membership modes, highest first
+	PrefixSymbols string            // symbols for PrefixModes, in the same order
+	CaseMapping   string            // rule for case-insensitive nick and channel comparison
+	NickLen       int               // maximum nick length
+	TargMax       map[string]int    // maximum number of targets per command, 0 for no limit
+	ChanModes     [4]string         // channel modes: list, always with parameter, with parameter when set, without parameter
+	Network       string            // network name
+	LineLen       int               // maximum line length, including CR LF
+	Tokens        map[string]string // all tokens, as advertised by the server
+}
+
+// DefaultISupport returns server features we assume before the server tells
+// us about them.
+func DefaultISupport() ISupport {
+	return ISupport{
+		ChanTypes:     "#&",
+		PrefixModes:   "ov",
+		PrefixSymbols: "@+",
+		CaseMapping:   "rfc1459",
+		NickLen:       9,
+		TargMax:       make(map[string]int),
+		ChanModes:     [4]string{"b", "k", "l", "imnpst"},
+		LineLen:       maxLength + len(endline),
+		Tokens:        make(map[string]string),
+	}
+}
+
+// copy returns a copy of i, that doesn't share maps with it.
+func (i ISupport) copy() ISupport {
+	r := i
+	r.TargMax = make(map[string]int, len(i.TargMax))
+	for k, v := range i.TargMax {
+		r.TargMax[k] = v
+	}
+	r.Tokens = make(map[string]string, len(i.Tokens))
+	for k, v := range i.Tokens {
+		r.Tokens[k] = v
+	}
+	return r
+}
+
+// parse updates features from RPL_ISUPPORT parameters.
+// <client> <1-13 tokens> :are supported by this server
+func (i *ISupport) parse(params []string) {
+	if len(params) < 2 {
+		return
+	}
+
+	for _, token := range params[1:] {
+		if strings.HasPrefix(token, "-") {
+			i.unset(token[1:])
+			continue
+		}
+
+		var value string
+		if n := strings.IndexByte(token, '='); n >= 0 {
+			token, value = token[:n], unescapeISupport(token[n+1:])
+		}
+		i.Tokens[token] = value
+		i.set(token, value)
+	}
+}
+
+// set updates a typed field for a single token. Invalid values are ignored.
+func (i *ISupport) set(token, value string) {
+	switch token {
+	case "CHANTYPES":
+		i.ChanTypes = value
+	case "PREFIX":
+		// (modes)symbols
+		if value == "" {
+			i.PrefixModes, i.PrefixSymbols = "", ""
+			return
+		}
+		n := strings.IndexByte(value, ')')
+		if !strings.HasPrefix(value, "(") || n < 0 || len(value[1:n]) != len(value[n+1:]) {
+			return
+		}
+		i.PrefixModes, i.PrefixSymbols = value[1:n], value[n+1:]
+	case "CASEMAPPING":
+		i.CaseMapping = strings.ToLower(value)
+	case "NICKLEN":
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			i.NickLen = n
+		}
+	case "TARGMAX":
+		i.TargMax = make(map[string]int)
+		for _, limit := range strings.Split(value, ",") {
+			var n int
+			if c := strings.IndexByte(limit, ':'); c >= 0 {
+				n, _ = strconv.Atoi(limit[c+1:])
+				limit = limit[:c]
+			}
+			if limit != "" {
+				i.TargMax[strings.ToUpper(limit)] = n
+			}
+		}
+	case "CHANMODES":
+		copy(i.ChanModes[:], strings.SplitN(value, ",", 4))
+	case "NETWORK":
+		i.Network = value
+	case "LINELEN":
+		if n, err := strconv.Atoi(value); err == nil && n > len(endline) {
+			i.LineLen = n
+		}
+	}
+}
+
+// unset restores a token's default value, when the server stops advertising it.
+func (i *ISupport) unset(token string) {
+	delete(i.Tokens, token)
+
+	d := DefaultISupport()
+	switch token {
+	case "CHANTYPES":
+		i.ChanTypes = d.ChanTypes
+	case "PREFIX":
+		i.PrefixModes, i.PrefixSymbols = d.PrefixModes, d.PrefixSymbols
+	case "CASEMAPPING":
+		i.CaseMapping = d.CaseMapping
+	case "NICKLEN":
+		i.NickLen = d.NickLen
+	case "TARGMAX":
+		i.TargMax = d.TargMax
+	case "CHANMODES":
+		i.ChanModes = d.ChanModes
+	case "NETWORK":
+		i.Network = d.Network
+	case "LINELEN":
+		i.LineLen = d.LineLen
+	}
+}
+
+// unescapeISupport decodes \xHH escapes used in ISUPPORT token values.
+func unescapeISupport(value string) string {
+	if strings.Index(value, `\x`) < 0 {
+		return value
+	}
+
+	var r []byte
+	for n := 0; n < len(value); n++ {
+		if value[n] == '\\' && n+3 < len(value) && value[n+1] == 'x' {
+			if b, err := strconv.ParseUint(value[n+2:n+4], 16, 8); err == nil {
+				r = append(r, byte(b))
+				n += 3
+				continue
+			}
+		}
+		r = append(r, value[n])
+	}
+	return string(r)
+}
+
+// Supports checks if the server advertised a token.
+func (i ISupport) Supports(token string) bool {
+	_, ok := i.Tokens[strings.ToUpper(token)]
+	return ok
+}
+
+// IsChannel checks if name is a channel name.
+func (i ISupport) IsChannel(name string) bool {
+	return name != "" && strings.IndexByte(i.ChanTypes, name[0]) >= 0
+}
+
+// Fold maps s to lower case, using server's case mapping rules, so that it
+// can be compared with other folded names, or used as a map key.
+func (i ISupport) Fold(s string) string {
+	switch i.CaseMapping {
+	case "ascii":
+		return strings.Map(foldASCII, s)
+	case "strict-rfc1459":
+		return strings.Map(foldStrictRFC1459, s)
+	case "rfc1459", "":
+		return strings.Map(foldRFC1459, s)
+	default:
+		// rfc7613 and other unicode-aware mappings
+		return strings.ToLower(s)
+	}
+}
+
+// EqualFold compares nicks or channel names, using server's case mapping rules.
+func (i ISupport) EqualFold(a, b string) bool {
+	return i.Fold(a) == i.Fold(b)
+}
+
+func foldASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+func foldStrictRFC1459(r rune) rune {
+	switch r {
+	case '[':
+		return '{'
+	case ']':
+		return '}'
+	case '\\':
+		return '|'
+	}
+	return foldASCII(r)
+}
+
+func foldRFC1459(r rune) rune {
+	if r == '~' {
+		return '^'
+	}
+	return foldStrictRFC1459(r)
+}
+
+// handleISupport collects server features from RPL_ISUPPORT messages.
+func (c *Connection) handleISupport(msg *Message) {
+	if msg.Command != "005" {
+		return
+	}
+
+	c.isupportLock.Lock()
+	defer c.isupportLock.Unlock()
+	c.isupport.parse(msg.Params)
+}
+
+// resetISupport restores default features, before we register with a server.
+func (c *Connection) resetISupport() {
+	c.isupportLock.Lock()
+	defer c.isupportLock.Unlock()
+	c.isupport = DefaultISupport()
+}
+
+// ISupport returns features advertised by the server we're connected to.
+func (c *Connection) ISupport() ISupport {
+	c.isupportLock.RLock()
+	defer c.isupportLock.RUnlock()
+	return c.isupport.copy()
+}
+
+// maxLength returns the maximum length of a message we can send, without CR LF.
+// Longer lines are never sent, even if the server allows them.
+func (c *Connection) maxLength() int {
+	c.isupportLock.RLock()
+	defer c.isupportLock.RUnlock()
+	if length := c.isupport.LineLen - len(endline); length < maxLength {
+		return length
+	}
+	return maxLength
+}
+
+// equalFold compares nicks or channel names, using server's case mapping rules.
+func (c *Connection) equalFold(a, b string) bool {
+	c.isupportLock.RLock()
+	defer c.isupportLock.RUnlock()
+	return c.isupport.EqualFold(a, b)
+}
+
+// supports checks if the server advertised a token, without copying features.
+func (c *Connection) supports(token string) bool {
+	c.isupportLock.RLock()
+	defer c.isupportLock.RUnlock()
+	return c.isupport.Supports(token)
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestISupportParse(t *testing.T) {
+	i := DefaultISupport()
+	for _, raw := range []string{
+		":irc.test 005 gorepost CHANTYPES=#! PREFIX=(qaohv)~&@%+ CASEMAPPING=ascii NICKLEN=30 :are supported by this server",
+		":irc.test 005 gorepost TARGMAX=PRIVMSG:4,NOTICE:4,JOIN: CHANMODES=beI,k,l,BCMNORScimnpstz NETWORK=Test\\x20Net LINELEN=1024 MONITOR :are supported by this server",
+		":irc.test 005 gorepost -MONITOR :are supported by this server",
+	} {
+		msg, err := ParseMessage(raw)
+		if err != nil {
+			t.Fatal("can't parse", raw, err)
+		}
+		i.parse(msg.Params)
+	}
+
+	expected := ISupport{
+		ChanTypes:     "#!",
+		PrefixModes:   "qaohv",
+		PrefixSymbols: "~&@%+",
+		CaseMapping:   "ascii",
+		NickLen:       30,
+		TargMax:       map[string]int{"PRIVMSG": 4, "NOTICE": 4, "JOIN": 0},
+		ChanModes:     [4]string{"beI", "k", "l", "BCMNORScimnpstz"},
+		Network:       "Test Net",
+		LineLen:       1024,
+	}
+	i.Tokens = nil
+	if fmt.Sprintf("%+v", i) != fmt.Sprintf("%+v", expected) {
+		t.Logf("expected: %+v", expected)
+		t.Logf("actual  : %+v", i)
+		t.Fail()
+	}
+}
+
+var foldTests = []struct {
+	caseMapping string
+	a, b        string
+	equal       bool
+}{
+	{"rfc1459", "Gorepost[]\\~", "gorepost{}|^", true},
+	{"strict-rfc1459", "Gorepost[]\\", "gorepost{}|", true},
+	{"strict-rfc1459", "gorepost~", "gorepost^", false},
+	{"ascii", "GOREPOST", "gorepost", true},
+	{"ascii", "gorepost[]", "gorepost{}", false},
+	{"rfc7613", "ŻÓŁW", "żółw", true},
+}
+
+func TestISupportFold(t *testing.T) {
+	for _, e := range foldTests {
+		i := DefaultISupport()
+		i.CaseMapping = e.caseMapping
+		if r := i.EqualFold(e.a, e.b); r != e.equal {
+			t.Errorf("%s: EqualFold(%q, %q) = %v, expected %v", e.caseMapping, e.a, e.b, r, e.equal)
+		}
+	}
+}
+
+func TestISupportIsChannel(t *testing.T) {
+	i := DefaultISupport()
+	if !i.IsChannel("&local") || i.IsChannel("!safe") || i.IsChannel("") {
+		t.Error("wrong channel detection with default CHANTYPES")
+	}
+
+	i.parse([]string{"gorepost", "CHANTYPES=#!"})
+	if i.IsChannel("&local") || !i.IsChannel("!safe") || !i.IsChannel("#test") {
+		t.Error("wrong channel detection with CHANTYPES=#!")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// Keepalive settings, used when they're not configured for a network. Values
+// are in seconds.
+const (
+	defaultPingInterval = 60
+	defaultPingTimeout  = 120
+)
+
+// Prefix of tokens in our PING messages, so we can tell replies to them apart.
+const pingTokenPrefix = "gorepost-"
+
+// keepaliveState keeps track of our PINGs. Guarded by statusLock.
+type keepaliveState struct {
+	token string    // token of the PING we're waiting a reply for
+	sent  time.Time // when we sent the last PING
+	lag   time.Duration
+}
+
+// resetKeepalive forgets about outstanding PINGs, before we register with
+// a server.
+func (c *Connection) resetKeepalive() {
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	c.keepalive = keepaliveState{}
+}
+
+// handleKeepalive matches PONG replies to our PINGs, and measures lag.
+func (c *Connection) handleKeepalive(msg *Message) {
+	if msg.Command != "PONG" {
+		return
+	}
+
+	// PONG <server> :<token>, though some servers put token first.
+	token := msg.Trailing
+	if token == "" && len(msg.Params) > 0 {
+		token = msg.Params[len(msg.Params)-1]
+	}
+
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	if c.keepalive.token == "" || token != c.keepalive.token {
+		return
+	}
+	c.keepalive.lag = time.Since(c.keepalive.sent)
+	c.keepalive.token = ""
+}
+
+// ping sends a PING, if interval passed since the last one, unless we're
+// still waiting for a reply. Returns false if the reply didn't come within
+// timeout.
+func (c *Connection) ping(now time.Time, interval, timeout time.Duration) bool {
+	c.statusLock.Lock()
+	if c.keepalive.token != "" || now.Sub(c.keepalive.sent) < interval {
+		alive := c.keepalive.token == "" || now.Sub(c.keepalive.sent) < timeout
+		c.statusLock.Unlock()
+		return alive
+	}
+	c.keepalive.token = pingTokenPrefix + strconv.FormatInt(now.UnixNano(), 36)
+	c.keepalive.sent = now
+	token := c.keepalive.token
+	c.statusLock.Unlock()
+
+	c.Sender(Message{
+		Command:  "PING",
+		Trailing: token,
+	})
+	return true
+}
+
+// Pinger sends PING messages every "PingInterval" seconds, until done is
+// closed. If there's no reply within "PingTimeout" seconds, the connection is
+// considered dead and closed, so Keeper can reconnect.
+func (c *Connection) Pinger(done chan struct{}) {
+	interval := time.Duration(c.lookupFloat("PingInterval", defaultPingInterval) * float64(time.Second))
+	timeout := time.Duration(c.lookupFloat("PingTimeout", defaultPingTimeout) * float64(time.Second))
+	if interval <= 0 || timeout <= 0 {
+		return
+	}
+
+	// Check more often than we ping, so a dead link is noticed soon after
+	// timeout passes.
+	tick := interval
+	if timeout < tick {
+		tick = timeout
+	}
+	ticker := time.NewTicker(tick / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if !c.Registered() {
+			continue
+		}
+
+		if !c.ping(time.Now(), interval, timeout) {
+			log.Println(c.network, "no reply to PING in", timeout, "closing connection")
+			c.l.Lock()
+			select {
+			case <-done:
+				// Connection is already being taken care of.
+			default:
+				c.conn.Close()
+			}
+			c.l.Unlock()
+			return
+		}
+	}
+}
+
+// Lag returns round-trip time measured with the last PING that got a reply.
+// If we're waiting for a reply for longer than that, time we've been waiting
+// is returned instead.
+func (c *Connection) Lag() time.Duration {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+
+	if c.keepalive.token != "" {
+		if waiting := time.Since(c.keepalive.sent); waiting > c.keepalive.lag {
+			return waiting
+		}
+	}
+	return c.keepalive.lag
+}
@@ -10,6 +10,7 @@ package irc
 import (
 	"bytes"
 	"errors"
+	"sort"
 	"strings"
 )
 
@@ -18,11 +19,98 @@ const (
 	prefix     byte = 0x3A // Prefix or last argument
 	prefixUser byte = 0x21 // Username
 	prefixHost byte = 0x40 // Hostname
+	prefixTags byte = 0x40 // Message tags
 	space      byte = 0x20 // Separator
+	tagsSep    byte = 0x3B // Tag separator
+	tagValue   byte = 0x3D // Tag key/value separator
+	tagEscape  byte = 0x5C // Tag value escape character
 
-	maxLength = 510 // Maximum length is 512 - 2 for the line endings.
+	maxLength     = 510  // Maximum length is 512 - 2 for the line endings.
+	maxTagsLength = 4094 // Maximum length of tag data, excluding '@' and space.
 )
 
+// Tag value escape sequences, as defined by IRCv3 message-tags specification.
+var tagEscapes = []struct {
+	raw     byte
+	escaped byte
+}{
+	{';', ':'},
+	{' ', 's'},
+	{'\\', '\\'},
+	{'\r', 'r'},
+	{'\n', 'n'},
+}
+
+// escapeTagValue escapes a tag value for use on the wire.
+func escapeTagValue(value string) string {
+	buffer := new(bytes.Buffer)
+	for i := 0; i < len(value); i++ {
+		escaped := false
+		for _, e := range tagEscapes {
+			if value[i] == e.raw {
+				buffer.WriteByte(tagEscape)
+				buffer.WriteByte(e.escaped)
+				escaped = true
+				break
+			}
+		}
+		if !escaped {
+			buffer.WriteByte(value[i])
+		}
+	}
+	return buffer.String()
+}
+
+// unescapeTagValue reverses escapeTagValue. Invalid escape sequences are
+// replaced by the escaped character, and a trailing backslash is dropped.
+func unescapeTagValue(value string) string {
+	if strings.IndexByte(value, tagEscape) < 0 {
+		return value
+	}
+
+	buffer := new(bytes.Buffer)
+	for i := 0; i < len(value); i++ {
+		if value[i] != tagEscape {
+			buffer.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		if i >= len(value) {
+			break
+		}
+
+		unescaped := value[i]
+		for _, e := range tagEscapes {
+			if value[i] == e.escaped {
+				unescaped = e.raw
+				break
+			}
+		}
+		buffer.WriteByte(unescaped)
+	}
+	return buffer.String()
+}
+
+// ParseTags parses the tag section of a message, without the leading '@'.
+func ParseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, tag := range strings.Split(raw, string(tagsSep)) {
+		if len(tag) == 0 {
+			continue
+		}
+
+		if i := strings.IndexByte(tag, tagValue); i >= 0 {
+			tags[tag[:i]] = unescapeTagValue(tag[i+1:])
+		} else {
+			tags[tag] = ""
+		}
+	}
+
+	return tags
+}
+
 func cutsetFunc(r rune) bool {
 	// Characters to trim from prefixes/messages.
 	return r == '\r' || r == '\n'
@@ -147,8 +235,16 @@ func (p *Prefix) writeTo(buffer *bytes.Buffer) {
 //                   NUL or CR or LF>
 //
 //    <crlf>     ::= CR LF
+//
+// Messages may additionally be preceded by IRCv3 message tags, which are stored,
+// already unescaped, in Tags.
+//
+//    <message>  ::= ['@' <tags> <SPACE>] [':' <prefix> <SPACE> ] <command> ...
+//    <tags>     ::= <tag> [';' <tag>]*
+//    <tag>      ::= <key> ['=' <escaped value>]
 type Message struct {
 	Context map[string]string
+	Tags    map[string]string
 	*Prefix
 	Command  string
 	Params   []string
@@ -171,6 +267,26 @@ func ParseMessage(raw string) (m *Message, err error) {
 
 	m = new(Message)
 
+	if raw[0] == prefixTags {
+
+		// Tags end with a space.
+		i = strings.IndexByte(raw, space)
+
+		// Tags must not be empty if the indicator is present.
+		if i < 2 {
+			return nil, errors.New("tags must not be empty if the indicator is present")
+		}
+
+		m.Tags = ParseTags(raw[1:i])
+
+		// Skip tags, and any spaces following them.
+		raw = strings.TrimLeft(raw[i+1:], string(space))
+		if len(raw) < 2 {
+			return nil, errors.New("empty message")
+		}
+		i = 0
+	}
+
 	if raw[0] == prefix {
 
 		// Prefix ends with a space.
@@ -234,11 +350,68 @@ func ParseMessage(raw string) (m *Message, err error) {
 
 }
 
+// writeTags is an utility function to write message tags to the bytes.Buffer in
+// Message.Bytes(). Tags are written in a stable order, and ones which don't fit
+// in the tag length limit are discarded.
+func (m *Message) writeTags(buffer *bytes.Buffer) {
+	if len(m.Tags) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		if len(k) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	tags := new(bytes.Buffer)
+	for _, k := range keys {
+		tag := k
+		if v := m.Tags[k]; len(v) > 0 {
+			tag = tag + string(tagValue) + escapeTagValue(v)
+		}
+
+		length := len(tag)
+		if tags.Len() > 0 {
+			length++
+		}
+		if tags.Len()+length > maxTagsLength {
+			continue
+		}
+
+		if tags.Len() > 0 {
+			tags.WriteByte(tagsSep)
+		}
+		tags.WriteString(tag)
+	}
+
+	if tags.Len() == 0 {
+		return
+	}
+
+	buffer.WriteByte(prefixTags)
+	buffer.Write(tags.Bytes())
+	buffer.WriteByte(space)
+}
+
+// TagsLen calculates the length of the tag section of this message on the wire,
+// including the leading '@' and the trailing space. It's accounted for
+// separately from the rest of the message, which is limited to maxLength.
+func (m *Message) TagsLen() int {
+	buffer := new(bytes.Buffer)
+	m.writeTags(buffer)
+	return buffer.Len()
+}
+
 // Len calculates the length of the string representation of this message.
 func (m *Message) Len() (length int) {
 
+	length = m.TagsLen()
+
 	if m.Prefix != nil {
-		length = m.Prefix.Len() + 2 // Include prefix and trailing space
+		length = length + m.Prefix.Len() + 2 // Include prefix and trailing space
 	}
 
 	length = length + len(m.Command)
@@ -261,11 +434,16 @@ func (m *Message) Len() (length int) {
 //
 // As noted in rfc2812 section 2.3, messages should not exceed 512 characters
 // in length. This method forces that limit by discarding any characters
-// exceeding the length limit.
+// exceeding the length limit. Message tags have their own limit, and are not
+// included in the 512 characters.
 func (m *Message) Bytes() []byte {
 
 	buffer := new(bytes.Buffer)
 
+	// Message tags
+	m.writeTags(buffer)
+	tagsLength := buffer.Len()
+
 	// Message prefix
 	if m.Prefix != nil {
 		buffer.WriteByte(prefix)
@@ -289,13 +467,16 @@ func (m *Message) Bytes() []byte {
 	}
 
 	// We need the limit the buffer length.
-	if buffer.Len() > (maxLength) {
-		buffer.Truncate(maxLength)
+	if buffer.Len() > (tagsLength + maxLength) {
+		buffer.Truncate(tagsLength + maxLength)
 	}
 
 	return buffer.Bytes()
 }
 
+// WireLen calculates the length of this message on the wire, without applying
+// the length limit and without message tags, so it can be compared against
+// maxLength. Use TagsLen for the length of tags.
 func (m *Message) WireLen() int {
 
 	buffer := new(bytes.Buffer)
@@ -6,6 +6,7 @@ package irc
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -277,6 +278,83 @@ var messageTests = [...]*struct {
 		rawMessage: "PASS oauth:token_goes_here",
 		rawPrefix:  "",
 	},
+	{
+		parsed: &Message{
+			Tags: map[string]string{
+				"aaa":             "bbb",
+				"ccc":             "",
+				"example.com/ddd": "eee",
+			},
+			Prefix: &Prefix{
+				Name: "nick",
+				User: "ident",
+				Host: "host.com",
+			},
+			Command:  "PRIVMSG",
+			Params:   []string{"me"},
+			Trailing: "Hello",
+		},
+		rawMessage: "@aaa=bbb;ccc;example.com/ddd=eee :nick!ident@host.com PRIVMSG me :Hello",
+		rawPrefix:  "nick!ident@host.com",
+		hostmask:   true,
+	},
+	{
+		parsed: &Message{
+			Tags: map[string]string{
+				"a": "b c;d\\e\r\n",
+			},
+			Command:  "PING",
+			Trailing: "x",
+		},
+		rawMessage: "@a=b\\sc\\:d\\\\e\\r\\n PING :x",
+	},
+	{
+		parsed: &Message{
+			Tags: map[string]string{
+				"+draft/reply": "abc",
+			},
+			Prefix: &Prefix{
+				Name: "irc.example.com",
+			},
+			Command: "TAGMSG",
+			Params:  []string{"#chan"},
+		},
+		rawMessage: "@+draft/reply=abc :irc.example.com TAGMSG #chan",
+		rawPrefix:  "irc.example.com",
+		server:     true,
+	},
+	{
+		rawMessage: "@ PRIVMSG test :Invalid message with empty tags.",
+	},
+	{
+		rawMessage: "@a=b ",
+	},
+}
+
+var tagTests = []struct {
+	raw  string
+	tags map[string]string
+}{
+	{
+		raw:  "a=b",
+		tags: map[string]string{"a": "b"},
+	},
+	{
+		raw:  "a=",
+		tags: map[string]string{"a": ""},
+	},
+	{
+		raw:  "a=\\b\\",
+		tags: map[string]string{"a": "b"},
+	},
+	{
+		raw:  "a=\\s\\s;;b=\\:",
+		tags: map[string]string{"a": "  ", "b": ";"},
+	},
+	{
+		raw:  "a=1;a=2",
+		tags: map[string]string{"a": "2"},
+	},
 }
 
 // -----
@@ -444,6 +522,51 @@ func TestParseMessage(t *testing.T) {
 	}
 }
 
+// -----
+// TAGS
+// -----
+
+func TestParseTags(t *testing.T) {
+	for i, test := range tagTests {
+		tags := ParseTags(test.raw)
+		if !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("Failed to parse tags %d:", i)
+			t.Logf("Output: %#v", tags)
+			t.Logf("Expected: %#v", test.tags)
+		}
+	}
+}
+
+func TestMessage_TagsLen(t *testing.T) {
+	m := &Message{
+		Tags: map[string]string{
+			"long": strings.Repeat("a", 600),
+		},
+		Command:  "PRIVMSG",
+		Params:   []string{"#test"},
+		Trailing: strings.Repeat("b", 600),
+	}
+
+	tagsLength := len("@long=") + 600 + 1
+	if m.TagsLen() != tagsLength {
+		t.Errorf("Wrong tags length: %d, expected %d", m.TagsLen(), tagsLength)
+	}
+
+	// Tags don't count towards maxLength
+	if m.WireLen() != len("PRIVMSG #test :")+600 {
+		t.Errorf("Wrong wire length: %d", m.WireLen())
+	}
+	if len(m.Bytes()) != tagsLength+maxLength {
+		t.Errorf("Wrong truncated length: %d, expected %d", len(m.Bytes()), tagsLength+maxLength)
+	}
+
+	// Tags which don't fit in their own budget are discarded
+	m.Tags["huge"] = strings.Repeat("c", maxTagsLength)
+	if m.TagsLen() != tagsLength {
+		t.Errorf("Oversized tag was not discarded, tags length: %d", m.TagsLen())
+	}
+}
+
 // -----
 // MESSAGE DECODE -> ENCODE
 // -----
@@ -0,0 +1,214 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often we try to get our primary nick back, if it's not configured.
+const defaultNickRegainInterval = 60
+
+// nickState keeps track of nick changes forced by collisions. Guarded by
+// selfLock, as regainer reads it.
+type nickState struct {
+	registered bool   // server accepted our NICK and USER
+	attempt    int    // how many nicks we've tried so far
+	primary    string // nick we'd like to have
+	monitoring bool   // we've asked the server to MONITOR primary nick
+}
+
+// nickCandidate returns n-th nick to try while registering: primary nick
+// first, then configured "AltNicks", then primary nick with a suffix.
+func (c *Connection) nickCandidate(n int) string {
+	if n == 0 {
+		return c.nick.primary
+	}
+
+	var alternatives []string
+	for _, nick := range c.cfg.LookupStringSlice(c.cfgContext(), "AltNicks") {
+		if nick != "" {
+			alternatives = append(alternatives, nick)
+		}
+	}
+	if n <= len(alternatives) {
+		return alternatives[n-1]
+	}
+
+	n -= len(alternatives)
+	if n == 1 {
+		return c.nick.primary + "_"
+	}
+	return c.nick.primary + strconv.Itoa(n)
+}
+
+// handleNick reacts to nick collisions during registration, and takes care of
+// regaining primary nick afterwards.
+func (c *Connection) handleNick(msg *Message) {
+	c.selfLock.Lock()
+	out := c.nickReply(msg)
+	c.selfLock.Unlock()
+
+	// Sender needs selfLock too.
+	for _, m := range out {
+		c.Sender(m)
+	}
+}
+
+// nickReply updates nick state, and returns messages to send in response.
+// Has to be called with selfLock held.
+func (c *Connection) nickReply(msg *Message) []Message {
+	switch msg.Command {
+	case "433", "436", "437":
+		// ERR_NICKNAMEINUSE, ERR_NICKCOLLISION, ERR_UNAVAILRESOURCE
+		// <client> <nick> :<reason>
+		// 437 may also be about a channel we can't join.
+		if len(msg.Params) < 2 || c.nick.registered || !c.equalFold(msg.Params[1], c.self.Name) {
+			return nil
+		}
+
+		c.nick.attempt++
+		c.self.Name = c.nickCandidate(c.nick.attempt)
+		log.Println(c.network, "nick", msg.Params[1], "unavailable, trying", c.self.Name)
+		return []Message{{
+			Command:  "NICK",
+			Trailing: c.self.Name,
+		}}
+	case "001":
+		c.nick.registered = true
+	case "376", "422":
+		// End of MOTD, or no MOTD. ISUPPORT has been sent by now.
+		if c.supports("MONITOR") && !c.nick.monitoring && !c.equalFold(c.self.Name, c.nick.primary) {
+			c.nick.monitoring = true
+			return []Message{{
+				Command: "MONITOR",
+				Params:  []string{"+", c.nick.primary},
+			}}
+		}
+	case "731":
+		// RPL_MONOFFLINE <client> :<target>{,<target>}
+		for _, target := range strings.Split(msg.Trailing, ",") {
+			if c.equalFold(ParsePrefix(target).Name, c.nick.primary) {
+				return c.regain()
+			}
+		}
+	case "303":
+		// RPL_ISON <client> :[<nick>{ <nick>}]
+		if !c.nick.registered {
+			return nil
+		}
+		for _, nick := range strings.Fields(msg.Trailing) {
+			if c.equalFold(nick, c.nick.primary) {
+				return nil
+			}
+		}
+		return c.regain()
+	case "NICK":
+		// handleSelf already updated our nick.
+		if c.equalFold(c.self.Name, c.nick.primary) && c.nick.monitoring {
+			c.nick.monitoring = false
+			return []Message{{
+				Command: "MONITOR",
+				Params:  []string{"-", c.nick.primary},
+			}}
+		}
+	}
+
+	return nil
+}
+
+// regain returns a message changing our nick back to the primary one. Has to
+// be called with selfLock held.
+func (c *Connection) regain() []Message {
+	if c.equalFold(c.self.Name, c.nick.primary) {
+		return nil
+	}
+	log.Println(c.network, "trying to regain nick", c.nick.primary)
+	return []Message{{
+		Command:  "NICK",
+		Trailing: c.nick.primary,
+	}}
+}
+
+// SetPrimaryNick makes nick the one we try to get, and keep, instead of
+// configured "Nick", until we reconnect. It doesn't change our nick; send NICK
+// for that.
+func (c *Connection) SetPrimaryNick(nick string) {
+	c.selfLock.Lock()
+	out := c.setPrimary(nick)
+	c.selfLock.Unlock()
+
+	for _, m := range out {
+		c.Sender(m)
+	}
+}
+
+// setPrimary changes primary nick, and returns MONITOR messages switching to
+// the new one. Has to be called with selfLock held.
+func (c *Connection) setPrimary(nick string) []Message {
+	var out []Message
+	if c.nick.monitoring {
+		c.nick.monitoring = false
+		out = append(out, Message{Command: "MONITOR", Params: []string{"-", c.nick.primary}})
+	}
+	c.nick.primary = nick
+	if c.nick.registered && c.supports("MONITOR") && !c.equalFold(c.self.Name, nick) {
+		c.nick.monitoring = true
+		out = append(out, Message{Command: "MONITOR", Params: []string{"+", nick}})
+	}
+	return out
+}
+
+// Regainer periodically checks if our primary nick became available, on
+// networks without MONITOR support, until done is closed. "NickRegainInterval"
+// is the time between checks in seconds.
+func (c *Connection) Regainer(done chan struct{}) {
+	interval := c.lookupFloat("NickRegainInterval", defaultNickRegainInterval)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval * float64(time.Second)))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		c.selfLock.RLock()
+		check := c.nick.registered && !c.equalFold(c.self.Name, c.nick.primary)
+		primary := c.nick.primary
+		c.selfLock.RUnlock()
+
+		if check && !c.supports("MONITOR") {
+			c.Sender(Message{
+				Command:  "ISON",
+				Trailing: primary,
+			})
+		}
+	}
+}
+
+// Registered checks if the server accepted our registration, and we're good
+// to go.
+func (c *Connection) Registered() bool {
+	c.selfLock.RLock()
+	defer c.selfLock.RUnlock()
+	return c.nick.registered
+}
+
+// Nick returns our current nick on this connection, which may differ from
+// configured one after a collision.
+func (c *Connection) Nick() string {
+	c.selfLock.RLock()
+	defer c.selfLock.RUnlock()
+	return c.self.Name
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func messageStrings(messages []Message) []string {
+	var r []string
+	for _, m := range messages {
+		r = append(r, m.String())
+	}
+	return r
+}
+
+func TestSetPrimaryNick(t *testing.T) {
+	var c Connection
+	c.isupport = DefaultISupport()
+	c.nick = nickState{registered: true, primary: "gorepost"}
+	c.self = Prefix{Name: "gorepost"}
+
+	if out := c.setPrimary("renamed"); len(out) != 0 {
+		t.Errorf("unexpected messages without MONITOR: %v", out)
+	}
+
+	// We're still gorepost, until the server accepts NICK. ISON replies
+	// without our old nick shouldn't switch us back.
+	msg, _ := ParseMessage(":irc.test 303 gorepost :")
+	if out := c.nickReply(msg); fmt.Sprint(messageStrings(out)) != "[NICK :renamed]" {
+		t.Errorf("expected to go for new nick, got %v", out)
+	}
+	c.self.Name = "renamed"
+	if out := c.nickReply(msg); len(out) != 0 {
+		t.Errorf("expected to keep new nick, got %v", out)
+	}
+
+	c.isupport.Tokens["MONITOR"] = "100"
+	c.nick.monitoring = true
+	c.self.Name = "renamed_"
+	if out := c.setPrimary("other"); fmt.Sprint(messageStrings(out)) != "[MONITOR - renamed MONITOR + other]" {
+		t.Errorf("expected MONITOR target to change, got %v", out)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Default flood control settings, used when they're not configured for
+// a network. They're conservative enough for most networks.
+const (
+	defaultSendBurst = 5
+	defaultSendRate  = 0.5
+)
+
+// urgentCommands are sent ahead of everything else, and aren't held back by
+// flood control. Delaying them could get us disconnected, or keep
+// registration from completing.
+var urgentCommands = map[string]bool{
+	"PONG":         true,
+	"PING":         true,
+	"PASS":         true,
+	"CAP":          true,
+	"AUTHENTICATE": true,
+	"NICK":         true,
+	"USER":         true,
+	"QUIT":         true,
+}
+
+// QueueStats describes the state of connection's outgoing message queue.
+type QueueStats struct {
+	Queued  int    // messages waiting to be sent
+	Sent    uint64 // messages sent since the connection was set up
+	Dropped uint64 // messages dropped on connection resets
+}
+
+// queueLimits describe token buckets used for flood control.
+type queueLimits struct {
+	burst    int     // how many lines can be sent at once
+	rate     float64 // lines per second
+	byteRate float64 // bytes per second, 0 for no limit
+}
+
+// sendQueue is an outgoing message queue, drained by Transmitter at the rate
+// allowed by token buckets.
+type sendQueue struct {
+	l      sync.Mutex
+	urgent []Message
+	normal []Message
+	wake   chan struct{}
+
+	tokens float64 // lines we can send right now
+	bytes  float64 // bytes we can send right now
+	last   time.Time
+
+	// writing is set while an urgent message is taken off the queue, but
+	// not written yet.
+	writing bool
+
+	sent    uint64
+	dropped uint64
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// push adds a message to the queue, and wakes up Transmitter.
+func (q *sendQueue) push(msg Message) {
+	q.l.Lock()
+	if urgentCommands[msg.Command] {
+		q.urgent = append(q.urgent, msg)
+	} else {
+		q.normal = append(q.normal, msg)
+	}
+	q.l.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// byteCapacity is the size of the byte bucket. It holds at least one full
+// line, so we can always make progress.
+func (limits queueLimits) byteCapacity() float64 {
+	if line := float64(maxLength + len(endline)); limits.byteRate < line {
+		return line
+	}
+	return limits.byteRate
+}
+
+// cost calculates how many bytes sending a message uses up.
+func cost(msg Message) float64 {
+	length := msg.WireLen()
+	if length > maxLength {
+		length = maxLength
+	}
+	return float64(length + len(endline))
+}
+
+// refill adds tokens accumulated since last call.
+func (q *sendQueue) refill(now time.Time, limits queueLimits) {
+	if q.last.IsZero() {
+		q.tokens = float64(limits.burst)
+		q.bytes = limits.byteCapacity()
+	} else if elapsed := now.Sub(q.last).Seconds(); elapsed > 0 {
+		q.tokens += elapsed * limits.rate
+		q.bytes += elapsed * limits.byteRate
+	}
+	q.last = now
+
+	if q.tokens > float64(limits.burst) {
+		q.tokens = float64(limits.burst)
+	}
+	if q.bytes > limits.byteCapacity() {
+		q.bytes = limits.byteCapacity()
+	}
+}
+
+// pop takes the next message off the queue, if flood control allows sending
+// it now. If it doesn't, pop returns how long to wait. ok is false if there's
+// nothing to send.
+func (q *sendQueue) pop(now time.Time, limits queueLimits) (msg Message, wait time.Duration, ok bool) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.refill(now, limits)
+
+	if len(q.urgent) > 0 {
+		msg, q.urgent = q.urgent[0], q.urgent[1:]
+		q.take(msg, limits)
+		q.writing = true
+		return msg, 0, true
+	}
+
+	if len(q.normal) == 0 {
+		return msg, 0, false
+	}
+
+	msg = q.normal[0]
+
+	if q.tokens < 1 {
+		return Message{}, rateWait(1-q.tokens, limits.rate), true
+	}
+	if limits.byteRate > 0 && q.bytes < cost(msg) {
+		return Message{}, rateWait(cost(msg)-q.bytes, limits.byteRate), true
+	}
+
+	q.normal = q.normal[1:]
+	q.take(msg, limits)
+	return msg, 0, true
+}
+
+// take uses up tokens for a message that's about to be sent. Urgent messages
+// may leave buckets empty, but never in debt.
+func (q *sendQueue) take(msg Message, limits queueLimits) {
+	q.sent++
+
+	q.tokens--
+	if q.tokens < 0 {
+		q.tokens = 0
+	}
+
+	if limits.byteRate > 0 {
+		q.bytes -= cost(msg)
+		if q.bytes < 0 {
+			q.bytes = 0
+		}
+	}
+}
+
+// rateWait calculates how long it takes to accumulate missing tokens.
+func rateWait(missing, rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Second
+	}
+	return time.Duration(missing / rate * float64(time.Second))
+}
+
+// reset drops all queued messages, and returns how many were dropped.
+func (q *sendQueue) reset() int {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	n := len(q.urgent) + len(q.normal)
+	q.urgent = nil
+	q.normal = nil
+	q.dropped += uint64(n)
+	q.last = time.Time{}
+	q.writing = false
+
+	return n
+}
+
+// written is called by Transmitter once a message it took off the queue is
+// written to the server.
+func (q *sendQueue) written() {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.writing = false
+}
+
+// urgentPending checks if there are urgent messages waiting to be sent, or
+// being written.
+func (q *sendQueue) urgentPending() bool {
+	q.l.Lock()
+	defer q.l.Unlock()
+	return len(q.urgent) > 0 || q.writing
+}
+
+func (q *sendQueue) stats() QueueStats {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	return QueueStats{
+		Queued:  len(q.urgent) + len(q.normal),
+		Sent:    q.sent,
+		Dropped: q.dropped,
+	}
+}
+
+// lookupFloat looks up a numeric configuration value for this connection's
+// network. Returns def if the value is missing or not a number.
+func (c *Connection) lookupFloat(key string, def float64) float64 {
+	if v, ok := c.cfg.Lookup(c.cfgContext(), key).(float64); ok {
+		return v
+	}
+	return def
+}
+
+// queueLimits reads flood control settings: "SendBurst" (lines),
+// "SendRate" (lines per second) and "SendByteRate" (bytes per second,
+// optional).
+func (c *Connection) queueLimits() queueLimits {
+	limits := queueLimits{
+		burst:    int(c.lookupFloat("SendBurst", defaultSendBurst)),
+		rate:     c.lookupFloat("SendRate", defaultSendRate),
+		byteRate: c.lookupFloat("SendByteRate", 0),
+	}
+	if limits.burst < 1 {
+		limits.burst = 1
+	}
+	return limits
+}
+
+// QueueStats returns current state of the outgoing message queue.
+func (c *Connection) QueueStats() QueueStats {
+	return c.queue.stats()
+}
+
+// Transmitter sends messages from the outgoing queue to the server, observing
+// flood control limits, until done is closed.
+func (c *Connection) Transmitter(done chan struct{}) {
+	log.Println(c.network, "spawned Transmitter")
+	for {
+		// Connection might have been reset while we were writing.
+		select {
+		case <-done:
+			log.Println(c.network, "closing Transmitter")
+			return
+		default:
+		}
+
+		msg, wait, ok := c.queue.pop(time.Now(), c.queueLimits())
+
+		if ok && wait == 0 {
+			c.write(msg)
+			c.queue.written()
+			continue
+		}
+
+		var timer <-chan time.Time
+		if ok {
+			timer = time.After(wait)
+		}
+
+		select {
+		case <-done:
+			log.Println(c.network, "closing Transmitter")
+			return
+		case <-c.queue.wake:
+		case <-timer:
+		}
+	}
+}
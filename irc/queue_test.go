@@ -0,0 +1,138 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var queueTests = []struct {
+	desc     string
+	limits   queueLimits
+	in       []Message
+	at       []time.Duration // when pop is called, relative to the start
+	expected []string        // "" means pop should ask us to wait
+}{
+	{
+		desc:   "burst, then rate",
+		limits: queueLimits{burst: 2, rate: 1},
+		in: []Message{
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "1"},
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "2"},
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "3"},
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "4"},
+		},
+		at: []time.Duration{0, 0, 0, time.Second, time.Second, 2 * time.Second},
+		expected: []string{
+			"PRIVMSG #test :1",
+			"PRIVMSG #test :2",
+			"",
+			"PRIVMSG #test :3",
+			"",
+			"PRIVMSG #test :4",
+		},
+	},
+	{
+		desc:   "pong goes first, even without tokens",
+		limits: queueLimits{burst: 1, rate: 1},
+		in: []Message{
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "1"},
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "2"},
+			{Command: "PONG", Trailing: "irc.test"},
+		},
+		at: []time.Duration{0, 0, time.Second, time.Second},
+		expected: []string{
+			"PONG :irc.test",
+			"",
+			"PRIVMSG #test :1",
+			"",
+		},
+	},
+	{
+		desc:   "byte rate",
+		limits: queueLimits{burst: 10, rate: 10, byteRate: 512},
+		in: []Message{
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", 400)},
+			{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("b", 400)},
+		},
+		at: []time.Duration{0, 0, time.Second},
+		expected: []string{
+			"PRIVMSG #test :" + strings.Repeat("a", 400),
+			"",
+			"PRIVMSG #test :" + strings.Repeat("b", 400),
+		},
+	},
+}
+
+func TestSendQueue(t *testing.T) {
+	start := time.Now()
+
+	for _, e := range queueTests {
+		t.Log("Running test", e.desc)
+
+		q := newSendQueue()
+		for _, msg := range e.in {
+			q.push(msg)
+		}
+
+		for i, at := range e.at {
+			msg, wait, ok := q.pop(start.Add(at), e.limits)
+			if !ok {
+				t.Errorf("step %d: queue unexpectedly empty", i)
+				break
+			}
+
+			var r string
+			if wait == 0 {
+				r = msg.String()
+			}
+			if r != e.expected[i] {
+				t.Errorf("step %d: expected %q, got %q (wait %v)", i, e.expected[i], r, wait)
+			}
+		}
+	}
+}
+
+func TestSendQueueReset(t *testing.T) {
+	q := newSendQueue()
+	q.push(Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "1"})
+	q.push(Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "2"})
+	q.push(Message{Command: "PONG", Trailing: "irc.test"})
+
+	q.pop(time.Now(), queueLimits{burst: 1, rate: 1})
+
+	if s := q.stats(); s != (QueueStats{Queued: 2, Sent: 1}) {
+		t.Errorf("unexpected stats before reset: %+v", s)
+	}
+
+	if n := q.reset(); n != 2 {
+		t.Errorf("expected 2 messages dropped, got %d", n)
+	}
+
+	if s := q.stats(); s != (QueueStats{Queued: 0, Sent: 1, Dropped: 2}) {
+		t.Errorf("unexpected stats after reset: %+v", s)
+	}
+
+	if _, _, ok := q.pop(time.Now(), queueLimits{burst: 1, rate: 1}); ok {
+		t.Error("queue should be empty after reset")
+	}
+}
+
+func TestSendQueuePending(t *testing.T) {
+	q := newSendQueue()
+	q.push(Message{Command: "QUIT", Trailing: "bye"})
+
+	q.pop(time.Now(), queueLimits{burst: 1, rate: 1})
+	if !q.urgentPending() {
+		t.Error("QUIT not pending until it's written")
+	}
+
+	q.written()
+	if q.urgentPending() {
+		t.Error("QUIT still pending after it's written")
+	}
+}
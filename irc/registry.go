@@ -0,0 +1,36 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"sync"
+)
+
+var connections = make(map[string]*Connection)
+var connectionsLock sync.RWMutex
+
+// register makes connection available through GetConnection.
+func register(c *Connection) {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	connections[c.network] = c
+}
+
+// unregister removes connection from registry, if it's still registered.
+func unregister(c *Connection) {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	if connections[c.network] == c {
+		delete(connections, c.network)
+	}
+}
+
+// GetConnection returns connection to given network, or nil if there's no such
+// connection.
+func GetConnection(network string) *Connection {
+	connectionsLock.RLock()
+	defer connectionsLock.RUnlock()
+	return connections[network]
+}
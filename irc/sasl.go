@@ -0,0 +1,224 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+// Maximum length of a single AUTHENTICATE payload chunk.
+const saslChunkLength = 400
+
+// saslState keeps track of SASL negotiation during connection registration.
+type saslState struct {
+	mechanisms []string // mechanisms left to try
+	current    string   // mechanism currently in progress
+	active     bool     // negotiation is in progress
+}
+
+// startRegistration initializes IRC connection. If there are capabilities to
+// negotiate, or SASL mechanisms are configured for this network, capability
+// negotiation is started before sending NICK and USER, so the server holds
+// registration until we're done.
+func (c *Connection) startRegistration() {
+	context := c.cfgContext()
+
+	c.sasl = saslState{}
+	c.setAccount("")
+	c.resetISupport()
+	c.state.reset()
+	c.resetKeepalive()
+	c.selfLock.Lock()
+	c.nick = nickState{primary: c.cfg.LookupString(context, "Nick")}
+	c.self = Prefix{Name: c.nick.primary}
+	c.selfLock.Unlock()
+	for _, m := range c.cfg.LookupStringSlice(context, "SASLMechanisms") {
+		if m != "" {
+			c.sasl.mechanisms = append(c.sasl.mechanisms, strings.ToUpper(m))
+		}
+	}
+
+	c.startCapabilities()
+
+	c.Sender(Message{
+		Command:  "NICK",
+		Trailing: c.Nick(),
+	})
+	c.Sender(Message{
+		Command:  "USER",
+		Params:   []string{c.cfg.LookupString(context, "User"), "0", "*"},
+		Trailing: c.cfg.LookupString(context, "RealName"),
+	})
+}
+
+// startSASL starts authentication, once the server acknowledged sasl
+// capability. If the server advertised supported mechanisms, the ones it
+// doesn't support are skipped.
+func (c *Connection) startSASL(advertised string) {
+	if advertised != "" {
+		var mechanisms []string
+		for _, m := range c.sasl.mechanisms {
+			for _, a := range strings.Split(advertised, ",") {
+				if strings.EqualFold(m, a) {
+					mechanisms = append(mechanisms, m)
+				}
+			}
+		}
+		c.sasl.mechanisms = mechanisms
+	}
+
+	c.sasl.active = true
+	c.saslNextMechanism()
+}
+
+// handleSASL handles messages related to SASL authentication. It's called
+// from Receiver, before the message is passed to dispatcher.
+func (c *Connection) handleSASL(msg *Message) {
+	switch msg.Command {
+	case "900":
+		// RPL_LOGGEDIN <nick> <nick>!<ident>@<host> <account> :<text>
+		if len(msg.Params) > 2 {
+			c.setAccount(msg.Params[2])
+		}
+		log.Println(c.network, "SASL:", msg.Trailing)
+		return
+	case "901":
+		c.setAccount("")
+		return
+	case "ACCOUNT":
+		// account-notify: ACCOUNT <account>, "*" when logging out. Tells us
+		// about logging in some other way than SASL.
+		if msg.Prefix != nil && len(msg.Params) > 0 && c.equalFold(msg.Prefix.Name, c.Nick()) {
+			if msg.Params[0] == "*" {
+				c.setAccount("")
+			} else {
+				c.setAccount(msg.Params[0])
+			}
+		}
+		return
+	}
+
+	if !c.sasl.active {
+		return
+	}
+
+	switch msg.Command {
+	case "AUTHENTICATE":
+		if msg.Trailing == "+" || (len(msg.Params) > 0 && msg.Params[0] == "+") {
+			c.saslRespond()
+		}
+	case "903", "907":
+		log.Println(c.network, "SASL authentication using", c.sasl.current, "successful")
+		c.sasl.active = false
+		c.capMaybeEnd()
+	case "904", "905":
+		log.Println(c.network, "SASL authentication using", c.sasl.current, "failed:", msg.Trailing)
+		c.saslNextMechanism()
+	case "902", "906":
+		c.saslFailed(msg.Trailing)
+		c.capMaybeEnd()
+	case "908":
+		// RPL_SASLMECHS <client> <mechanisms> :are available SASL mechanisms
+		if len(msg.Params) > 1 {
+			log.Println(c.network, "SASL mechanisms supported by server:", msg.Params[1])
+		}
+	case "001":
+		c.sasl.active = false
+	}
+}
+
+func (c *Connection) setAccount(account string) {
+	c.accountLock.Lock()
+	defer c.accountLock.Unlock()
+	c.account = account
+}
+
+// Account returns the name of the account we're logged in as, or an empty string
+// if we're not logged in, or the server didn't tell us. Servers tell us with
+// RPL_LOGGEDIN, after SASL, and usually after identifying with services, and
+// with ACCOUNT, if account-notify is enabled.
+func (c *Connection) Account() string {
+	c.accountLock.RLock()
+	defer c.accountLock.RUnlock()
+	return c.account
+}
+
+// saslNextMechanism starts authentication with the next configured mechanism,
+// or falls back if there are none left.
+func (c *Connection) saslNextMechanism() {
+	if len(c.sasl.mechanisms) == 0 {
+		c.saslFailed("no more mechanisms to try")
+		c.capMaybeEnd()
+		return
+	}
+
+	c.sasl.current, c.sasl.mechanisms = c.sasl.mechanisms[0], c.sasl.mechanisms[1:]
+	log.Println(c.network, "SASL: trying", c.sasl.current)
+	c.Sender(Message{
+		Command: "AUTHENTICATE",
+		Params:  []string{c.sasl.current},
+	})
+}
+
+// saslRespond sends credentials for the mechanism in progress, split into
+// chunks as required by the protocol.
+func (c *Connection) saslRespond() {
+	var payload string
+	context := c.cfgContext()
+
+	switch c.sasl.current {
+	case "PLAIN":
+		user := c.cfg.LookupString(context, "SASLUser")
+		if user == "" {
+			user = c.cfg.LookupString(context, "Nick")
+		}
+		payload = base64.StdEncoding.EncodeToString([]byte(
+			user + "\x00" + user + "\x00" + c.cfg.LookupString(context, "SASLPassword"),
+		))
+	case "EXTERNAL":
+		// Identity is taken from the TLS client certificate.
+	default:
+		log.Println(c.network, "SASL: unsupported mechanism", c.sasl.current)
+		c.Sender(Message{
+			Command: "AUTHENTICATE",
+			Params:  []string{"*"},
+		})
+		return
+	}
+
+	for len(payload) >= saslChunkLength {
+		c.Sender(Message{
+			Command: "AUTHENTICATE",
+			Params:  []string{payload[:saslChunkLength]},
+		})
+		payload = payload[saslChunkLength:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	c.Sender(Message{
+		Command: "AUTHENTICATE",
+		Params:  []string{payload},
+	})
+}
+
+// saslFailed applies configured "SASLFallback" policy: "continue" (default)
+// lets registration complete without authentication, "disconnect" drops the
+// connection, so we will reconnect and try again.
+func (c *Connection) saslFailed(reason string) {
+	log.Println(c.network, "SASL authentication failed:", reason)
+	c.sasl.active = false
+
+	if c.cfg.LookupString(c.cfgContext(), "SASLFallback") == "disconnect" {
+		log.Println(c.network, "SASL fallback: disconnecting")
+		c.caps.negotiating = false
+		c.conn.Close()
+		return
+	}
+
+	log.Println(c.network, "SASL fallback: continuing without authentication")
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ellipsis   = "…"
+	ctcpAction = "\x01ACTION "
+
+	// Used in place of our own hostname, until the server tells us what it
+	// is. That's the longest hostname servers will relay.
+	unknownHostLength = 63
+	// Same for username, which may get prefixed with '~' by the server.
+	unknownUserLength = 10
+)
+
+// handleSelf keeps track of the prefix the server uses for our own messages:
+// nick!user@host. Servers may tell us about it in RPL_WELCOME; otherwise we
+// learn it from our own messages relayed back to us, like JOINs.
+func (c *Connection) handleSelf(msg *Message) {
+	c.selfLock.Lock()
+	defer c.selfLock.Unlock()
+
+	switch msg.Command {
+	case "001":
+		if len(msg.Params) > 0 {
+			c.self.Name = msg.Params[0]
+		}
+		if fields := strings.Fields(msg.Trailing); len(fields) > 0 {
+			if p := ParsePrefix(fields[len(fields)-1]); p.IsHostmask() && c.equalFold(p.Name, c.self.Name) {
+				c.self = *p
+			}
+		}
+		return
+	case "396":
+		// RPL_VISIBLEHOST <nick> <host> :is now your displayed host
+		if len(msg.Params) > 1 {
+			c.self.Host = msg.Params[1]
+			log.Println(c.network, "displayed host changed to", c.self.Host)
+		}
+		return
+	}
+
+	if msg.Prefix == nil || !c.equalFold(msg.Prefix.Name, c.self.Name) {
+		return
+	}
+
+	if msg.Prefix.IsHostmask() {
+		c.self.User, c.self.Host = msg.Prefix.User, msg.Prefix.Host
+	}
+	if msg.Command == "NICK" {
+		if len(msg.Params) > 0 {
+			c.self.Name = msg.Params[0]
+		} else if msg.Trailing != "" {
+			c.self.Name = msg.Trailing
+		}
+	}
+}
+
+// selfPrefix returns our own prefix, as the server will relay it. Parts we
+// don't know yet are replaced by placeholders of the maximum length.
+func (c *Connection) selfPrefix() Prefix {
+	c.selfLock.RLock()
+	self := c.self
+	c.selfLock.RUnlock()
+
+	if self.Name == "" {
+		self.Name = c.cfg.LookupString(c.cfgContext(), "Nick")
+	}
+	if self.User == "" {
+		self.User = strings.Repeat("*", unknownUserLength)
+	}
+	if self.Host == "" {
+		self.Host = strings.Repeat("*", unknownHostLength)
+	}
+	return self
+}
+
+// splitMessage splits PRIVMSG or NOTICE messages whose trailing parameter
+// doesn't fit in a single line, into several messages. The length budget
+// accounts for self, the prefix the server will add when relaying our message,
+// and length, the maximum message length without CR LF.
+// Splits happen on word boundaries if possible, and never in the middle of
+// a UTF-8 sequence. If maxLines is greater than zero, text that doesn't fit
+// in as many messages is truncated, and an ellipsis is added.
+func splitMessage(msg Message, self Prefix, length, maxLines int) []Message {
+	if msg.Command != "PRIVMSG" && msg.Command != "NOTICE" {
+		return []Message{msg}
+	}
+
+	relayed := msg
+	relayed.Prefix = &self
+	relayed.Tags = nil
+	relayed.Trailing = ""
+	relayed.EmptyTrailing = true
+	limit := length - relayed.WireLen()
+
+	if len(msg.Trailing) <= limit {
+		return []Message{msg}
+	}
+
+	text := msg.Trailing
+	var head, tail string
+	if strings.HasPrefix(text, ctcpAction) && strings.HasSuffix(text, "\x01") && len(text) > len(ctcpAction) {
+		head, tail = ctcpAction, "\x01"
+		text = text[len(head) : len(text)-len(tail)]
+		limit = limit - len(head) - len(tail)
+	}
+
+	var r []Message
+	for _, line := range splitText(text, limit, maxLines) {
+		m := msg
+		m.Trailing = head + line + tail
+		r = append(r, m)
+	}
+
+	return r
+}
+
+// splitText splits text into chunks no longer than limit bytes.
+func splitText(text string, limit, maxLines int) []string {
+	var r []string
+
+	if limit < utf8.UTFMax+len(ellipsis) {
+		limit = utf8.UTFMax + len(ellipsis)
+	}
+
+	for len(text) > limit {
+		if maxLines > 0 && len(r) == maxLines-1 {
+			line, _ := splitPoint(text, limit-len(ellipsis))
+			return append(r, line+ellipsis)
+		}
+
+		line, rest := splitPoint(text, limit)
+		r = append(r, line)
+		text = rest
+	}
+
+	return append(r, text)
+}
+
+// splitPoint cuts text at the last space before limit, or at the last rune
+// boundary before limit, if there's no space to split on.
+func splitPoint(text string, limit int) (string, string) {
+	if len(text) <= limit {
+		return text, ""
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	if i := strings.LastIndexByte(text[:cut+1], ' '); i > 0 {
+		return text[:i], strings.TrimLeft(text[i:], " ")
+	}
+
+	return text[:cut], text[cut:]
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var splitSelf = Prefix{Name: "gorepost", User: "~gorepost", Host: "example.com"}
+
+// Longest trailing parameter we can send to #test, as relayed with splitSelf.
+var splitLimit = maxLength - len(":gorepost!~gorepost@example.com PRIVMSG #test :")
+
+var splitTests = []struct {
+	desc     string
+	in       Message
+	maxLines int
+	expected []string // trailing parameters of resulting messages
+}{
+	{
+		desc:     "short message",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "hello"},
+		expected: []string{"hello"},
+	},
+	{
+		desc:     "exact fit",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", splitLimit)},
+		expected: []string{strings.Repeat("a", splitLimit)},
+	},
+	{
+		desc:     "split on word boundary",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", splitLimit-2) + " bbbb cc"},
+		expected: []string{strings.Repeat("a", splitLimit-2), "bbbb cc"},
+	},
+	{
+		desc:     "notice",
+		in:       Message{Command: "NOTICE", Params: []string{"#test"}, Trailing: strings.Repeat("a", splitLimit+11)},
+		expected: []string{strings.Repeat("a", splitLimit+1), strings.Repeat("a", 10)}, // NOTICE is one byte shorter
+	},
+	{
+		desc:     "no spaces",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", splitLimit+10)},
+		expected: []string{strings.Repeat("a", splitLimit), strings.Repeat("a", 10)},
+	},
+	{
+		desc:     "don't split runes",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", splitLimit-1) + "żółw"},
+		expected: []string{strings.Repeat("a", splitLimit-1), "żółw"},
+	},
+	{
+		desc:     "action",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: "\x01ACTION " + strings.Repeat("a", splitLimit-20) + " " + strings.Repeat("b", 20) + "\x01"},
+		expected: []string{"\x01ACTION " + strings.Repeat("a", splitLimit-20) + "\x01", "\x01ACTION " + strings.Repeat("b", 20) + "\x01"},
+	},
+	{
+		desc:     "line limit",
+		in:       Message{Command: "PRIVMSG", Params: []string{"#test"}, Trailing: strings.Repeat("a", 3*splitLimit)},
+		maxLines: 2,
+		expected: []string{strings.Repeat("a", splitLimit), strings.Repeat("a", splitLimit-len(ellipsis)) + ellipsis},
+	},
+	{
+		desc:     "other commands are left alone",
+		in:       Message{Command: "TOPIC", Params: []string{"#test"}, Trailing: strings.Repeat("a", 2*splitLimit)},
+		expected: []string{strings.Repeat("a", 2*splitLimit)},
+	},
+}
+
+func TestSplitMessage(t *testing.T) {
+	for _, e := range splitTests {
+		t.Log("Running test", e.desc)
+
+		r := splitMessage(e.in, splitSelf, maxLength, e.maxLines)
+		if len(r) != len(e.expected) {
+			t.Errorf("expected %d messages, got %d: %+v", len(e.expected), len(r), r)
+			continue
+		}
+
+		for i, msg := range r {
+			if msg.Trailing != e.expected[i] {
+				t.Errorf("message %d: expected %q, got %q", i, e.expected[i], msg.Trailing)
+			}
+			if !utf8.ValidString(msg.Trailing) {
+				t.Errorf("message %d is not valid UTF-8: %q", i, msg.Trailing)
+			}
+			if e.in.Command == "PRIVMSG" || e.in.Command == "NOTICE" {
+				msg.Prefix = &splitSelf
+				if msg.WireLen() > maxLength {
+					t.Errorf("message %d too long when relayed: %d", i, msg.WireLen())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Token we use to recognize replies to our WHOX queries.
+const whoxToken = "42"
+
+// User describes an IRC user sharing a channel with us.
+type User struct {
+	Nick     string
+	User     string
+	Host     string
+	Account  string // empty if not logged in, or unknown
+	RealName string
+	Away     bool
+}
+
+// Channel describes a channel we're on.
+type Channel struct {
+	Name    string
+	Topic   string
+	Modes   map[string]string // channel modes, with parameters if they have any
+	Members map[string]string // nicks of channel members, and their membership modes
+}
+
+// channelState is the tracked state of a single channel. Nicks are folded
+// using server's case mapping.
+type channelState struct {
+	name    string
+	topic   string
+	modes   map[byte]string
+	members map[string]string
+	names   bool // RPL_NAMREPLY list is coming in
+}
+
+// stateTracker keeps track of channels we're on, and users we share them
+// with. It's updated from Receiver, and read by plugins running concurrently,
+// so everything is guarded by l.
+type stateTracker struct {
+	l        sync.RWMutex
+	channels map[string]*channelState
+	users    map[string]*User
+}
+
+// trackedCommands are the messages stateTracker cares about.
+var trackedCommands = map[string]bool{
+	"JOIN": true, "PART": true, "KICK": true, "QUIT": true, "NICK": true,
+	"MODE": true, "TOPIC": true, "ACCOUNT": true, "AWAY": true, "CHGHOST": true,
+	"324": true, "331": true, "332": true, "352": true, "353": true,
+	"354": true, "366": true,
+}
+
+// reset forgets everything, before we register with a server.
+func (s *stateTracker) reset() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.channels = make(map[string]*channelState)
+	s.users = make(map[string]*User)
+}
+
+// user returns a tracked user, adding it if it's not known yet.
+func (s *stateTracker) user(server ISupport, nick string) *User {
+	key := server.Fold(nick)
+	u, ok := s.users[key]
+	if !ok {
+		u = &User{Nick: nick}
+		s.users[key] = u
+	}
+	return u
+}
+
+// updateUser fills in user details from a message prefix.
+func (s *stateTracker) updateUser(server ISupport, prefix *Prefix) *User {
+	u := s.user(server, prefix.Name)
+	if prefix.User != "" {
+		u.User = prefix.User
+	}
+	if prefix.Host != "" {
+		u.Host = prefix.Host
+	}
+	return u
+}
+
+// forget drops a user who doesn't share any channels with us anymore.
+func (s *stateTracker) forget(key string) {
+	for _, ch := range s.channels {
+		if _, ok := ch.members[key]; ok {
+			return
+		}
+	}
+	delete(s.users, key)
+}
+
+// part removes a user from a channel. If it's us, the whole channel is dropped.
+func (s *stateTracker) part(server ISupport, me, channel, nick string) {
+	ch, ok := s.channels[server.Fold(channel)]
+	if !ok {
+		return
+	}
+
+	if server.EqualFold(nick, me) {
+		delete(s.channels, server.Fold(channel))
+		for key := range ch.members {
+			s.forget(key)
+		}
+		return
+	}
+
+	delete(ch.members, server.Fold(nick))
+	s.forget(server.Fold(nick))
+}
+
+// args returns all message parameters, including the trailing one.
+func args(msg *Message) []string {
+	r := append([]string{}, msg.Params...)
+	if msg.Trailing != "" || msg.EmptyTrailing {
+		r = append(r, msg.Trailing)
+	}
+	return r
+}
+
+// handle updates channel and user state. Returns messages that should be sent
+// in response.
+func (s *stateTracker) handle(server ISupport, me string, msg *Message) []Message {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	a := args(msg)
+	var nick string
+	if msg.Prefix != nil {
+		nick = msg.Prefix.Name
+	}
+
+	switch msg.Command {
+	case "JOIN":
+		if len(a) < 1 || nick == "" {
+			return nil
+		}
+		key := server.Fold(a[0])
+		ch, ok := s.channels[key]
+		if server.EqualFold(nick, me) {
+			ch = &channelState{
+				name:    a[0],
+				modes:   make(map[byte]string),
+				members: make(map[string]string),
+			}
+			s.channels[key] = ch
+		} else if !ok {
+			return nil
+		}
+		u := s.updateUser(server, msg.Prefix)
+		ch.members[server.Fold(nick)] = ""
+		// extended-join: JOIN <channel> <account> :<realname>
+		if len(a) > 2 {
+			u.Account = a[1]
+			if u.Account == "*" {
+				u.Account = ""
+			}
+			u.RealName = a[2]
+		}
+
+		// Ask for members' details, and for modes set before we joined.
+		if server.EqualFold(nick, me) {
+			mode := Message{Command: "MODE", Params: []string{a[0]}}
+			if server.Supports("WHOX") {
+				return []Message{{Command: "WHO", Params: []string{a[0], "%tcuhnfar," + whoxToken}}, mode}
+			}
+			return []Message{{Command: "WHO", Params: []string{a[0]}}, mode}
+		}
+	case "PART":
+		if len(a) > 0 && nick != "" {
+			s.part(server, me, a[0], nick)
+		}
+	case "KICK":
+		if len(a) > 1 {
+			s.part(server, me, a[0], a[1])
+		}
+	case "QUIT":
+		key := server.Fold(nick)
+		for _, ch := range s.channels {
+			delete(ch.members, key)
+		}
+		delete(s.users, key)
+	case "NICK":
+		if len(a) < 1 || nick == "" {
+			return nil
+		}
+		oldKey, newKey := server.Fold(nick), server.Fold(a[0])
+		if u, ok := s.users[oldKey]; ok {
+			delete(s.users, oldKey)
+			u.Nick = a[0]
+			s.users[newKey] = u
+		}
+		for _, ch := range s.channels {
+			if modes, ok := ch.members[oldKey]; ok {
+				delete(ch.members, oldKey)
+				ch.members[newKey] = modes
+			}
+		}
+	case "MODE":
+		if len(a) < 2 {
+			return nil
+		}
+		if ch, ok := s.channels[server.Fold(a[0])]; ok {
+			ch.applyModes(server, a[1], a[2:])
+		}
+	case "324":
+		// RPL_CHANNELMODEIS <client> <channel> <modestring> <mode arguments>...
+		if len(a) < 3 {
+			return nil
+		}
+		if ch, ok := s.channels[server.Fold(a[1])]; ok {
+			ch.modes = make(map[byte]string)
+			ch.applyModes(server, a[2], a[3:])
+		}
+	case "TOPIC":
+		if len(a) < 2 {
+			return nil
+		}
+		if ch, ok := s.channels[server.Fold(a[0])]; ok {
+			ch.topic = a[1]
+		}
+	case "331", "332":
+		// RPL_NOTOPIC, RPL_TOPIC <client> <channel> :<topic>
+		if len(a) < 3 {
+			return nil
+		}
+		if ch, ok := s.channels[server.Fold(a[1])]; ok {
+			ch.topic = a[2]
+			if msg.Command == "331" {
+				ch.topic = ""
+			}
+		}
+	case "353":
+		// RPL_NAMREPLY <client> <symbol> <channel> :[prefix]<nick>{ [prefix]<nick>}
+		if len(a) < 4 {
+			return nil
+		}
+		ch, ok := s.channels[server.Fold(a[2])]
+		if !ok {
+			return nil
+		}
+		if !ch.names {
+			ch.members = make(map[string]string)
+			ch.names = true
+		}
+		for _, name := range strings.Fields(a[3]) {
+			var modes string
+			for len(name) > 0 {
+				n := strings.IndexByte(server.PrefixSymbols, name[0])
+				if n < 0 {
+					break
+				}
+				modes = addMode(modes, server.PrefixModes[n], server.PrefixModes)
+				name = name[1:]
+			}
+			// userhost-in-names
+			p := ParsePrefix(name)
+			s.updateUser(server, p)
+			ch.members[server.Fold(p.Name)] = modes
+		}
+	case "366":
+		// RPL_ENDOFNAMES <client> <channel> :End of /NAMES list
+		if len(a) < 2 {
+			return nil
+		}
+		if ch, ok := s.channels[server.Fold(a[1])]; ok {
+			ch.names = false
+			for key := range s.users {
+				s.forget(key)
+			}
+		}
+	case "352":
+		// RPL_WHOREPLY <client> <channel> <username> <host> <server> <nick> <flags> :<hopcount> <realname>
+		if len(a) < 8 {
+			return nil
+		}
+		s.who(server, a[1], a[2], a[3], a[5], a[6], "", a[7], false)
+	case "354":
+		// RPL_WHOSPCRPL, in response to our "%tcuhnfar" query:
+		// <client> <token> <channel> <user> <host> <nick> <flags> <account> :<realname>
+		if len(a) < 9 || a[1] != whoxToken {
+			return nil
+		}
+		s.who(server, a[2], a[3], a[4], a[5], a[6], a[7], a[8], true)
+	case "ACCOUNT":
+		if len(a) < 1 || nick == "" {
+			return nil
+		}
+		if u, ok := s.users[server.Fold(nick)]; ok {
+			u.Account = a[0]
+			if u.Account == "*" {
+				u.Account = ""
+			}
+		}
+	case "AWAY":
+		if u, ok := s.users[server.Fold(nick)]; ok {
+			u.Away = len(a) > 0 && a[0] != ""
+		}
+	case "CHGHOST":
+		if len(a) < 2 {
+			return nil
+		}
+		if u, ok := s.users[server.Fold(nick)]; ok {
+			u.User, u.Host = a[0], a[1]
+		}
+	}
+
+	return nil
+}
+
+// who updates user details from WHO and WHOX replies.
+func (s *stateTracker) who(server ISupport, channel, user, host, nick, flags, account, realname string, whox bool) {
+	ch, ok := s.channels[server.Fold(channel)]
+	if !ok {
+		return
+	}
+	key := server.Fold(nick)
+	if _, ok := ch.members[key]; !ok {
+		return
+	}
+
+	u := s.user(server, nick)
+	u.User, u.Host = user, host
+	u.Away = strings.HasPrefix(flags, "G")
+
+	if whox {
+		u.RealName = realname
+		u.Account = account
+		if u.Account == "0" {
+			u.Account = ""
+		}
+	} else if n := strings.IndexByte(realname, ' '); n >= 0 {
+		// "<hopcount> <realname>"
+		u.RealName = realname[n+1:]
+	}
+
+	var modes string
+	for i := 0; i < len(flags); i++ {
+		if n := strings.IndexByte(server.PrefixSymbols, flags[i]); n >= 0 {
+			modes = addMode(modes, server.PrefixModes[n], server.PrefixModes)
+		}
+	}
+	ch.members[key] = modes
+}
+
+// applyModes applies a mode change to a channel. Modes taking parameters are
+// recognized using CHANMODES and PREFIX features advertised by the server.
+func (ch *channelState) applyModes(server ISupport, modes string, params []string) {
+	adding := true
+	next := func() string {
+		if len(params) == 0 {
+			return ""
+		}
+		p := params[0]
+		params = params[1:]
+		return p
+	}
+
+	for i := 0; i < len(modes); i++ {
+		m := modes[i]
+		switch {
+		case m == '+':
+			adding = true
+		case m == '-':
+			adding = false
+		case strings.IndexByte(server.PrefixModes, m) >= 0:
+			key := server.Fold(next())
+			if current, ok := ch.members[key]; ok {
+				if adding {
+					ch.members[key] = addMode(current, m, server.PrefixModes)
+				} else {
+					ch.members[key] = strings.Replace(current, string(m), "", -1)
+				}
+			}
+		case strings.IndexByte(server.ChanModes[0], m) >= 0:
+			// Lists, like bans, aren't tracked.
+			next()
+		case strings.IndexByte(server.ChanModes[1], m) >= 0:
+			p := next()
+			if adding {
+				ch.modes[m] = p
+			} else {
+				delete(ch.modes, m)
+			}
+		case strings.IndexByte(server.ChanModes[2], m) >= 0:
+			if adding {
+				ch.modes[m] = next()
+			} else {
+				delete(ch.modes, m)
+			}
+		default:
+			if adding {
+				ch.modes[m] = ""
+			} else {
+				delete(ch.modes, m)
+			}
+		}
+	}
+}
+
+// addMode adds a membership mode, keeping modes ordered as in order.
+func addMode(modes string, m byte, order string) string {
+	var r []byte
+	for i := 0; i < len(order); i++ {
+		if order[i] == m || strings.IndexByte(modes, order[i]) >= 0 {
+			r = append(r, order[i])
+		}
+	}
+	return string(r)
+}
+
+// handleState keeps track of channels and users, and asks the server for
+// details of channels we join.
+func (c *Connection) handleState(msg *Message) {
+	if !trackedCommands[msg.Command] {
+		return
+	}
+
+	for _, m := range c.state.handle(c.ISupport(), c.Nick(), msg) {
+		c.Sender(m)
+	}
+}
+
+// Channels returns names of channels we're on.
+func (c *Connection) Channels() []string {
+	c.state.l.RLock()
+	defer c.state.l.RUnlock()
+
+	var r []string
+	for _, ch := range c.state.channels {
+		r = append(r, ch.name)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Channel returns the state of a channel we're on. ok is false if we're not
+// on that channel.
+func (c *Connection) Channel(name string) (channel Channel, ok bool) {
+	server := c.ISupport()
+
+	c.state.l.RLock()
+	defer c.state.l.RUnlock()
+
+	ch, ok := c.state.channels[server.Fold(name)]
+	if !ok {
+		return channel, false
+	}
+
+	channel = Channel{
+		Name:    ch.name,
+		Topic:   ch.topic,
+		Modes:   make(map[string]string, len(ch.modes)),
+		Members: make(map[string]string, len(ch.members)),
+	}
+	for m, p := range ch.modes {
+		channel.Modes[string(m)] = p
+	}
+	for key, modes := range ch.members {
+		nick := key
+		if u, ok := c.state.users[key]; ok {
+			nick = u.Nick
+		}
+		channel.Members[nick] = modes
+	}
+	return channel, true
+}
+
+// User returns what we know about a user sharing a channel with us. ok is
+// false if we don't share any channels.
+func (c *Connection) User(nick string) (user User, ok bool) {
+	server := c.ISupport()
+
+	c.state.l.RLock()
+	defer c.state.l.RUnlock()
+
+	u, ok := c.state.users[server.Fold(nick)]
+	if !ok {
+		return user, false
+	}
+	return *u, true
+}
+
+// UserChannels returns names of channels we share with a user.
+func (c *Connection) UserChannels(nick string) []string {
+	server := c.ISupport()
+	key := server.Fold(nick)
+
+	c.state.l.RLock()
+	defer c.state.l.RUnlock()
+
+	var r []string
+	for _, ch := range c.state.channels {
+		if _, ok := ch.members[key]; ok {
+			r = append(r, ch.name)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
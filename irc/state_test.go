@@ -0,0 +1,103 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+)
+
+var stateInput = []string{
+	":irc.test 005 gorepost PREFIX=(ov)@+ CHANMODES=b,k,l,imnst WHOX :are supported by this server",
+	":gorepost!repost@example.com JOIN #Test",
+	":irc.test 332 gorepost #test :old topic",
+	":irc.test 353 gorepost = #test :@gorepost +Voiced Plain",
+	":irc.test 353 gorepost = #test :Leaving",
+	":irc.test 366 gorepost #test :End of /NAMES list.",
+	":irc.test 354 gorepost 42 #test plain plain.example.com Plain H account :Plain User",
+	":irc.test 354 gorepost 42 #test voiced voiced.example.com Voiced G+ 0 :Voiced User",
+	":irc.test 324 gorepost #test +nl 10",
+	":Someone!some@one.example.com JOIN #test someone :Some One",
+	":Plain!plain@plain.example.com NICK :Renamed",
+	":Renamed!plain@plain.example.com MODE #test +o-l+k Renamed key",
+	":Voiced!voiced@voiced.example.com TOPIC #test :new topic",
+	":Leaving!leaving@example.com PART #test :bye",
+	":Someone!some@one.example.com AWAY :gone",
+	":irc.test 353 gorepost = #other :gorepost", // not on #other
+}
+
+func TestStateTracking(t *testing.T) {
+	var c Connection
+	c.isupport = DefaultISupport()
+	c.state.reset()
+
+	var sent []string
+	for _, raw := range stateInput {
+		msg, err := ParseMessage(raw)
+		if err != nil {
+			t.Fatal("can't parse", raw, err)
+		}
+		c.handleISupport(msg)
+		for _, m := range c.state.handle(c.ISupport(), "gorepost", msg) {
+			sent = append(sent, m.String())
+		}
+	}
+
+	if fmt.Sprint(sent) != "[WHO #Test %tcuhnfar,42 MODE #Test]" {
+		t.Errorf("unexpected messages sent: %v", sent)
+	}
+
+	if fmt.Sprint(c.Channels()) != "[#Test]" {
+		t.Errorf("unexpected channels: %v", c.Channels())
+	}
+
+	ch, ok := c.Channel("#TEST")
+	if !ok {
+		t.Fatal("#test not tracked")
+	}
+	expected := Channel{
+		Name:    "#Test",
+		Topic:   "new topic",
+		Modes:   map[string]string{"k": "key", "n": ""},
+		Members: map[string]string{"gorepost": "o", "Voiced": "v", "Renamed": "o", "Someone": ""},
+	}
+	if fmt.Sprintf("%+v", ch) != fmt.Sprintf("%+v", expected) {
+		t.Logf("expected: %+v", expected)
+		t.Logf("actual  : %+v", ch)
+		t.Fail()
+	}
+
+	users := []User{
+		{Nick: "Renamed", User: "plain", Host: "plain.example.com", Account: "account", RealName: "Plain User"},
+		{Nick: "Voiced", User: "voiced", Host: "voiced.example.com", RealName: "Voiced User", Away: true},
+		{Nick: "Someone", User: "some", Host: "one.example.com", Account: "someone", RealName: "Some One", Away: true},
+	}
+	for _, e := range users {
+		if u, ok := c.User(e.Nick); !ok || u != e {
+			t.Errorf("expected user %+v, got %+v", e, u)
+		}
+	}
+
+	if _, ok := c.User("Leaving"); ok {
+		t.Error("user who left is still tracked")
+	}
+	if _, ok := c.Channel("#other"); ok {
+		t.Error("channel we're not on is tracked")
+	}
+
+	for _, raw := range []string{
+		":Someone!some@one.example.com QUIT :bye",
+		":gorepost!repost@example.com KICK #test gorepost :out",
+	} {
+		msg, _ := ParseMessage(raw)
+		c.state.handle(c.ISupport(), "gorepost", msg)
+		if u, ok := c.User("Someone"); ok {
+			t.Errorf("user still tracked after quitting: %+v", u)
+		}
+	}
+	if len(c.Channels()) != 0 || len(c.state.users) != 0 {
+		t.Errorf("state not cleared after being kicked: %v %v", c.Channels(), c.state.users)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+var errNoPeerCertificate = errors.New("server did not present a certificate")
+
+// lookupBool looks up a boolean configuration value for this connection's
+// network. Missing or non-boolean values are treated as false.
+func (c *Connection) lookupBool(key string) bool {
+	v, _ := c.cfg.Lookup(c.cfgContext(), key).(bool)
+	return v
+}
+
+// cfgContext returns configuration lookup context for this connection.
+func (c *Connection) cfgContext() map[string]string {
+	return map[string]string{"Network": c.network}
+}
+
+// normalizeFingerprint turns "AB:CD:..." and "abcd..." into the same form.
+func normalizeFingerprint(s string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+}
+
+// tlsConfig builds TLS client configuration for a given server, based on
+// per-network configuration:
+//
+//	TLSServerName   - server name used for SNI and certificate verification,
+//	                  defaults to host part of the server address
+//	TLSCAFile       - PEM bundle of CA certificates trusted instead of system
+//	                  roots
+//	TLSFingerprints - list of SHA-256 fingerprints of accepted server
+//	                  certificates; when set, the certificate has to match one
+//	                  of them, and CA verification is only performed if
+//	                  TLSCAFile is also set
+//	TLSCertFile     - PEM client certificate, used for SASL EXTERNAL
+//	TLSKeyFile      - PEM private key for TLSCertFile
+func (c *Connection) tlsConfig(server string) (*tls.Config, error) {
+	context := c.cfgContext()
+
+	serverName := c.cfg.LookupString(context, "TLSServerName")
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	config := &tls.Config{
+		ServerName: serverName,
+	}
+
+	if caFile := c.cfg.LookupString(context, "TLSCAFile"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	if certFile := c.cfg.LookupString(context, "TLSCertFile"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, c.cfg.LookupString(context, "TLSKeyFile"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var pins []string
+	for _, f := range c.cfg.LookupStringSlice(context, "TLSFingerprints") {
+		if f != "" {
+			pins = append(pins, normalizeFingerprint(f))
+		}
+	}
+
+	if len(pins) > 0 {
+		// Chain verification is done by hand in verifyPinned, as pinned
+		// certificates are quite often self-signed.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPinned(raw, pins, config.RootCAs, serverName)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPinned checks if server certificate matches one of the pinned
+// fingerprints and, if roots are provided, if it chains up to one of them.
+func verifyPinned(raw [][]byte, pins []string, roots *x509.CertPool, serverName string) error {
+	if len(raw) == 0 {
+		return errNoPeerCertificate
+	}
+
+	sum := sha256.Sum256(raw[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	matched := false
+	for _, pin := range pins {
+		if pin == fingerprint {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("certificate fingerprint %s is not pinned", fingerprint)
+	}
+
+	if roots == nil {
+		return nil
+	}
+
+	var certs []*x509.Certificate
+	for _, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(opts)
+	return err
+}
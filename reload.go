@@ -0,0 +1,334 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arachnist/dyncfg"
+	"github.com/arachnist/gorepost/bot"
+	"github.com/arachnist/gorepost/irc"
+)
+
+// networkConfig is the part of network configuration we reconcile on reload.
+type networkConfig struct {
+	servers  []string
+	channels []string
+}
+
+// networkSet keeps track of running connections, and configuration they were
+// last reconciled with. It's the bot.Controller for admin commands.
+type networkSet struct {
+	l           sync.Mutex
+	cfg         *dyncfg.Dyncfg
+	connections map[string]*irc.Connection
+	configured  map[string]networkConfig
+	quit        chan struct{} // receives quit requests from admin commands
+	quitMessage string        // overrides "QuitMessage", if set
+}
+
+func newNetworkSet(cfg *dyncfg.Dyncfg) *networkSet {
+	return &networkSet{
+		cfg:         cfg,
+		connections: make(map[string]*irc.Connection),
+		configured:  make(map[string]networkConfig),
+		quit:        make(chan struct{}, 1),
+	}
+}
+
+// lookup reads current configuration of a network.
+func (n *networkSet) lookup(network string) networkConfig {
+	context := map[string]string{"Network": network}
+	return networkConfig{
+		servers:  n.cfg.LookupStringSlice(context, "Servers"),
+		channels: append(n.cfg.LookupStringSlice(context, "Channels"), n.cfg.LookupStringSlice(context, "SecuredChannels")...),
+	}
+}
+
+// start sets up a connection to network. Channels are joined by plugins, once
+// we're registered. Has to be called with n.l held.
+func (n *networkSet) start(network string) {
+	conn := new(irc.Connection)
+	log.Println("Setting up", network, "connection")
+	conn.OnSent(bot.LogSent)
+	conn.Setup(bot.Dispatcher, network, n.cfg)
+	n.connections[network] = conn
+	n.configured[network] = n.lookup(network)
+}
+
+// stop quits network. Has to be called with n.l held.
+func (n *networkSet) stop(network string) {
+	log.Println("Quitting", network)
+	n.connections[network].Quit(n.cfg.LookupString(map[string]string{"Network": network}, "QuitMessage"))
+	delete(n.connections, network)
+	delete(n.configured, network)
+}
+
+// stopAll quits all networks at once.
+func (n *networkSet) stopAll() {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	var wg sync.WaitGroup
+	for network, conn := range n.connections {
+		log.Println("Quitting", network)
+		message := n.quitMessage
+		if message == "" {
+			message = n.cfg.LookupString(map[string]string{"Network": network}, "QuitMessage")
+		}
+		wg.Add(1)
+		go func(conn *irc.Connection, message string) {
+			defer wg.Done()
+			conn.Quit(message)
+		}(conn, message)
+	}
+	wg.Wait()
+}
+
+// Quit asks main loop to shut down, quitting networks with message.
+func (n *networkSet) Quit(message string) {
+	n.l.Lock()
+	n.quitMessage = message
+	n.l.Unlock()
+
+	select {
+	case n.quit <- struct{}{}:
+	default:
+		// Already requested.
+	}
+}
+
+// Reload reloads configuration, and reports changes to admin channels.
+func (n *networkSet) Reload() string {
+	summary := n.reload()
+	log.Println(summary)
+	n.report(summary)
+	return summary
+}
+
+// startAll connects to all configured networks.
+func (n *networkSet) startAll() {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for _, network := range n.cfg.LookupStringSlice(nil, "Networks") {
+		n.start(network)
+	}
+}
+
+// reload starts and stops connections to match configured "Networks", and
+// joins or parts channels, to match configured "Channels" and
+// "SecuredChannels" on each network. Channels we've joined for other reasons,
+// like invites, are left alone. Returns a summary of changes made.
+func (n *networkSet) reload() string {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	var running []string
+	for network := range n.connections {
+		running = append(running, network)
+	}
+	start, stop := networkDiff(running, n.cfg.LookupStringSlice(nil, "Networks"))
+
+	var summary []string
+	started := make(map[string]bool)
+	for _, network := range start {
+		log.Println("reload: starting", network)
+		n.start(network)
+		started[network] = true
+		summary = append(summary, "started "+network)
+	}
+	for _, network := range stop {
+		log.Println("reload: stopping", network)
+		n.stop(network)
+		summary = append(summary, "stopped "+network)
+	}
+
+	var networks []string
+	for network := range n.connections {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		conn := n.connections[network]
+		if started[network] {
+			continue
+		}
+		if !conn.Registered() {
+			log.Println("reload:", network, "not registered yet, channels will be joined on connect")
+			n.configured[network] = n.lookup(network)
+			continue
+		}
+		if changes := n.reconcile(network, conn); changes != "" {
+			summary = append(summary, network+": "+changes)
+		}
+	}
+
+	return reloadSummary(summary)
+}
+
+// reloadSummary joins changes made by reload into a line reported to admin
+// channels.
+func reloadSummary(changes []string) string {
+	if len(changes) == 0 {
+		return "reload: no changes"
+	}
+	return "reload: " + strings.Join(changes, "; ")
+}
+
+// networkDiff returns networks to start and stop, for running ones to match
+// wanted ones. Both lists are sorted.
+func networkDiff(running, wanted []string) (start, stop []string) {
+	isRunning := make(map[string]bool)
+	for _, network := range running {
+		isRunning[network] = true
+	}
+	isWanted := make(map[string]bool)
+	for _, network := range wanted {
+		if !isRunning[network] && !isWanted[network] {
+			start = append(start, network)
+		}
+		isWanted[network] = true
+	}
+	for _, network := range running {
+		if !isWanted[network] {
+			stop = append(stop, network)
+		}
+	}
+	sort.Strings(start)
+	sort.Strings(stop)
+	return start, stop
+}
+
+// channelDiff returns channels to join and part, going from previous to
+// current configuration, with joined channels we're on right now. Secured
+// channels are only joined once we're identified. Channels we're on, but
+// which were never configured, are left alone.
+func channelDiff(server irc.ISupport, previous, current, joined, secured []string, identified bool) (join, part []string) {
+	on := make(map[string]bool)
+	for _, channel := range joined {
+		on[server.Fold(channel)] = true
+	}
+	configured := make(map[string]bool)
+	for _, channel := range current {
+		configured[server.Fold(channel)] = true
+	}
+	isSecured := make(map[string]bool)
+	for _, channel := range secured {
+		isSecured[server.Fold(channel)] = true
+	}
+
+	for _, channel := range current {
+		key := server.Fold(channel)
+		if on[key] || (isSecured[key] && !identified) {
+			continue
+		}
+		on[key] = true
+		join = append(join, channel)
+	}
+	for _, channel := range previous {
+		key := server.Fold(channel)
+		if !on[key] || configured[key] {
+			continue
+		}
+		delete(on, key)
+		part = append(part, channel)
+	}
+	return join, part
+}
+
+// changeSummary describes changes made to a network by reconcile.
+func changeSummary(serversChanged bool, join, part []string) string {
+	var changes []string
+	if serversChanged {
+		changes = append(changes, "servers changed")
+	}
+	if len(join) > 0 {
+		changes = append(changes, "joined "+strings.Join(join, ", "))
+	}
+	if len(part) > 0 {
+		changes = append(changes, "parted "+strings.Join(part, ", "))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// reconcile joins and parts channels on a running connection, to match its
+// configuration. Has to be called with n.l held.
+func (n *networkSet) reconcile(network string, conn *irc.Connection) string {
+	previous := n.configured[network]
+	current := n.lookup(network)
+	n.configured[network] = current
+
+	serversChanged := fmt.Sprint(previous.servers) != fmt.Sprint(current.servers)
+	if serversChanged {
+		log.Println("reload:", network, "servers changed to", current.servers, "will be used on next reconnect")
+	}
+
+	secured := n.cfg.LookupStringSlice(map[string]string{"Network": network}, "SecuredChannels")
+	join, part := channelDiff(conn.ISupport(), previous.channels, current.channels, conn.Channels(), secured, bot.Identified(network))
+
+	for _, channel := range join {
+		log.Println("reload:", network, "joining channel", channel)
+		conn.Sender(irc.Message{
+			Command: "JOIN",
+			Params:  []string{channel},
+		})
+	}
+	for _, channel := range part {
+		log.Println("reload:", network, "parting channel", channel)
+		conn.Sender(irc.Message{
+			Command:  "PART",
+			Params:   []string{channel},
+			Trailing: "configuration changed",
+		})
+	}
+
+	return changeSummary(serversChanged, join, part)
+}
+
+// Status describes the state of every network's connection.
+func (n *networkSet) Status() []string {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	var r []string
+	for network, conn := range n.connections {
+		s := conn.Status()
+		switch {
+		case s.Connected:
+			r = append(r, fmt.Sprintf("%s: connected to %s since %v, lag %v", network, s.Server, s.ConnectedSince.Round(time.Second), conn.Lag().Round(time.Millisecond)))
+		case !s.NextAttempt.IsZero():
+			r = append(r, fmt.Sprintf("%s: %d failed attempts, last error: %s, next attempt at %v", network, s.Attempts, s.LastError, s.NextAttempt.Round(time.Second)))
+		default:
+			r = append(r, fmt.Sprintf("%s: connecting to %s", network, s.Server))
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// report sends reload summary to "AdminChannel" on every network it's
+// configured for.
+func (n *networkSet) report(summary string) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for network, conn := range n.connections {
+		channel := n.cfg.LookupString(map[string]string{"Network": network}, "AdminChannel")
+		if channel == "" {
+			continue
+		}
+		conn.Sender(irc.Message{
+			Command:  "PRIVMSG",
+			Params:   []string{channel},
+			Trailing: summary,
+		})
+	}
+}
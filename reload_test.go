@@ -0,0 +1,151 @@
+// Copyright 2015 Robert S. Gerus. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/arachnist/gorepost/irc"
+)
+
+var networkDiffTests = []struct {
+	desc    string
+	running []string
+	wanted  []string
+	start   []string
+	stop    []string
+}{
+	{
+		desc:    "no changes",
+		running: []string{"A", "B"},
+		wanted:  []string{"B", "A"},
+	},
+	{
+		desc:   "first start",
+		wanted: []string{"B", "A", "B"},
+		start:  []string{"A", "B"},
+	},
+	{
+		desc:    "networks added and removed",
+		running: []string{"A", "C", "B"},
+		wanted:  []string{"A", "D"},
+		start:   []string{"D"},
+		stop:    []string{"B", "C"},
+	},
+	{
+		desc:    "everything removed",
+		running: []string{"A"},
+		stop:    []string{"A"},
+	},
+}
+
+func TestNetworkDiff(t *testing.T) {
+	for _, e := range networkDiffTests {
+		start, stop := networkDiff(e.running, e.wanted)
+		if fmt.Sprint(start) != fmt.Sprint(e.start) || fmt.Sprint(stop) != fmt.Sprint(e.stop) {
+			t.Errorf("%s: expected start %v, stop %v, got start %v, stop %v", e.desc, e.start, e.stop, start, stop)
+		}
+	}
+}
+
+var channelDiffTests = []struct {
+	desc       string
+	previous   []string
+	current    []string
+	joined     []string
+	secured    []string
+	identified bool
+	join       []string
+	part       []string
+}{
+	{
+		desc:     "no changes",
+		previous: []string{"#a", "#b"},
+		current:  []string{"#a", "#b"},
+		joined:   []string{"#a", "#b"},
+	},
+	{
+		desc:     "channel added and removed",
+		previous: []string{"#a", "#b"},
+		current:  []string{"#a", "#c"},
+		joined:   []string{"#a", "#b"},
+		join:     []string{"#c"},
+		part:     []string{"#b"},
+	},
+	{
+		desc:     "case differs",
+		previous: []string{"#Chan[1]"},
+		current:  []string{"#CHAN{1}"},
+		joined:   []string{"#chan{1}"},
+	},
+	{
+		desc:     "rejoining a channel we got kicked from",
+		previous: []string{"#a"},
+		current:  []string{"#a"},
+		join:     []string{"#a"},
+	},
+	{
+		desc:     "removed channel we're not on",
+		previous: []string{"#a", "#b"},
+		current:  []string{"#a"},
+		joined:   []string{"#a"},
+	},
+	{
+		desc:     "channel joined on invite is left alone",
+		previous: []string{"#a"},
+		current:  []string{},
+		joined:   []string{"#a", "#invited"},
+		part:     []string{"#a"},
+	},
+	{
+		desc:    "secured channel, not identified",
+		current: []string{"#a", "#secret"},
+		secured: []string{"#secret"},
+		join:    []string{"#a"},
+	},
+	{
+		desc:       "secured channel, identified",
+		current:    []string{"#a", "#secret"},
+		secured:    []string{"#SECRET"},
+		identified: true,
+		join:       []string{"#a", "#secret"},
+	},
+}
+
+func TestChannelDiff(t *testing.T) {
+	server := irc.DefaultISupport()
+	for _, e := range channelDiffTests {
+		join, part := channelDiff(server, e.previous, e.current, e.joined, e.secured, e.identified)
+		if fmt.Sprint(join) != fmt.Sprint(e.join) || fmt.Sprint(part) != fmt.Sprint(e.part) {
+			t.Errorf("%s: expected join %v, part %v, got join %v, part %v", e.desc, e.join, e.part, join, part)
+		}
+	}
+}
+
+func TestReloadSummary(t *testing.T) {
+	for _, e := range []struct {
+		changes  []string
+		expected string
+	}{
+		{nil, "reload: no changes"},
+		{
+			[]string{"started D", "stopped B", "A: " + changeSummary(true, []string{"#c", "#d"}, []string{"#b"})},
+			"reload: started D; stopped B; A: servers changed, joined #c, #d, parted #b",
+		},
+		{
+			[]string{"A: " + changeSummary(false, nil, []string{"#b"})},
+			"reload: A: parted #b",
+		},
+	} {
+		if s := reloadSummary(e.changes); s != e.expected {
+			t.Errorf("expected %q, got %q", e.expected, s)
+		}
+	}
+
+	if s := changeSummary(false, nil, nil); s != "" {
+		t.Errorf("expected no changes, got %q", s)
+	}
+}